@@ -58,7 +58,11 @@ func logSnow(loc string, lat, lon float32) {
 func main() {
 	logger := new(MyLogger)
 	logger.Info("startup")
-	cs := services.NewCoastService(logger, ".")
+	cs, err := services.NewCoastService(logger, ".")
+	if err != nil {
+		logger.Errorf("%v", err)
+		os.Exit(1)
+	}
 
 	img := image.NewNRGBA(image.Rect(0,0,3600, 1800))
 
@@ -48,7 +48,11 @@ func (m *MyLogger) Errorf(format string, a ...interface{}) {
 func main() {
 	logger := new(MyLogger)
 	logger.Info("startup")
-	gs := services.NewGribService(logger, ".", "bin", services.NewCoastService(logger, "."))
+	cs, err := services.NewCoastService(logger, ".")
+	if err != nil {
+		logger.Errorf("No coastal snow: %v", err)
+	}
+	gs := services.NewGribService(logger, ".", "bin", cs)
 	//_, _ = gs.DownloadAndProcessGribFile(true, 0, 0, 0)
 	_, m, _ := gs.DownloadAndProcessGribFile(false, 01, 03, 18)
 
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The archive manifest is a JSON index of the historical GFS files that are really
+// available on the archive server or a mirror, e.g.
+//
+//	{
+//	  "version": 1,
+//	  "base_url": "https://github.com/xairline/weather-data/releases/download/daily/",
+//	  "entries": [
+//	    { "date": "2025-01-03", "cycle": 18, "forecast": 6, "file": "gfs.0p25.2025010318.f006.grib2" }
+//	  ]
+//	}
+//
+// An entry may carry a full "url" that takes precedence over base_url + file.
+
+const archiveManifestVersion = 1
+
+// max distance between requested time and an archive entry before we warn,
+// also the smallest gap listed by Describe
+const archiveMaxGap = 36 * time.Hour
+
+// a manifest that can't be loaded is not tried again before archiveRetry
+const (
+	archiveTimeout = 30 * time.Second
+	archiveRetry   = 10 * time.Minute
+)
+
+var archiveClient = &http.Client{Timeout: archiveTimeout}
+
+type ArchiveEntry struct {
+	Date     string `json:"date"` // yyyy-mm-dd of the cycle
+	Cycle    int    `json:"cycle"`
+	Forecast int    `json:"forecast"`
+	File     string `json:"file"`
+	Url      string `json:"url,omitempty"`
+
+	cycleTime time.Time
+}
+
+type ArchiveManifest struct {
+	Version int            `json:"version"`
+	BaseUrl string         `json:"base_url"`
+	Entries []ArchiveEntry `json:"entries"`
+
+	source string
+}
+
+// UTC time of the model run
+func (e *ArchiveEntry) CycleTime() time.Time {
+	return e.cycleTime
+}
+
+// UTC time the forecast is valid for
+func (e *ArchiveEntry) ValidTime() time.Time {
+	return e.cycleTime.Add(time.Duration(e.Forecast) * time.Hour)
+}
+
+func (m *ArchiveManifest) EntryUrl(e *ArchiveEntry) string {
+	if e.Url != "" {
+		return e.Url
+	}
+	base := m.BaseUrl
+	if base != "" && !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return base + e.File
+}
+
+// load manifest from a local file or a http(s) mirror
+func LoadArchiveManifest(src string) (*ArchiveManifest, error) {
+	var rd io.ReadCloser
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := archiveClient.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("can't get archive manifest '%s': %s", src, resp.Status)
+		}
+		rd = resp.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		rd = f
+	}
+	defer rd.Close()
+
+	m, err := ParseArchiveManifest(rd)
+	if err != nil {
+		return nil, fmt.Errorf("archive manifest '%s': %w", src, err)
+	}
+	m.source = src
+	return m, nil
+}
+
+func ParseArchiveManifest(rd io.Reader) (*ArchiveManifest, error) {
+	m := &ArchiveManifest{}
+	if err := json.NewDecoder(rd).Decode(m); err != nil {
+		return nil, err
+	}
+
+	if m.Version != archiveManifestVersion {
+		return nil, fmt.Errorf("unsupported version %d", m.Version)
+	}
+
+	for i := range m.Entries {
+		e := &m.Entries[i]
+		d, err := time.Parse("2006-01-02", e.Date)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		if e.Cycle < 0 || e.Cycle > 23 || e.Forecast < 0 {
+			return nil, fmt.Errorf("entry %d: invalid cycle/forecast %d/%d", i, e.Cycle, e.Forecast)
+		}
+		if e.File == "" && e.Url == "" {
+			return nil, fmt.Errorf("entry %d: neither file nor url", i)
+		}
+		e.cycleTime = d.Add(time.Duration(e.Cycle) * time.Hour)
+	}
+
+	sort.Slice(m.Entries, func(i, j int) bool {
+		return m.Entries[i].ValidTime().Before(m.Entries[j].ValidTime())
+	})
+	return m, nil
+}
+
+// entry with the valid time closest to timeUTC, nil if the manifest is empty
+func (m *ArchiveManifest) Nearest(timeUTC time.Time) *ArchiveEntry {
+	n := len(m.Entries)
+	if n == 0 {
+		return nil
+	}
+
+	i := sort.Search(n, func(i int) bool {
+		return !m.Entries[i].ValidTime().Before(timeUTC)
+	})
+
+	if i == n {
+		return &m.Entries[n-1]
+	}
+	if i == 0 {
+		return &m.Entries[0]
+	}
+
+	// prefer the older one on a tie, it's already observed snow
+	if m.Entries[i].ValidTime().Sub(timeUTC) < timeUTC.Sub(m.Entries[i-1].ValidTime()) {
+		return &m.Entries[i]
+	}
+	return &m.Entries[i-1]
+}
+
+type ArchiveGap struct {
+	From, To time.Time // last valid time before and first after the gap
+}
+
+// dates with data are summarized as first, last and gaps exceeding maxGap
+func (m *ArchiveManifest) Availability(maxGap time.Duration) (first, last time.Time, gaps []ArchiveGap) {
+	if len(m.Entries) == 0 {
+		return
+	}
+
+	first = m.Entries[0].ValidTime()
+	last = m.Entries[len(m.Entries)-1].ValidTime()
+	for i := 1; i < len(m.Entries); i++ {
+		t0 := m.Entries[i-1].ValidTime()
+		t1 := m.Entries[i].ValidTime()
+		if t1.Sub(t0) > maxGap {
+			gaps = append(gaps, ArchiveGap{t0, t1})
+		}
+	}
+	return
+}
+
+// one line summary + one line per gap longer than archiveMaxGap
+func (m *ArchiveManifest) Describe() []string {
+	first, last, gaps := m.Availability(archiveMaxGap)
+	if len(m.Entries) == 0 {
+		return []string{fmt.Sprintf("Archive '%s' is empty", m.source)}
+	}
+
+	const tf = "2006-01-02 15z"
+	lines := []string{fmt.Sprintf("Archive has %d datasets from %s to %s, %d gaps",
+		len(m.Entries), first.Format(tf), last.Format(tf), len(gaps))}
+	for _, g := range gaps {
+		lines = append(lines, fmt.Sprintf("  no data between %s and %s", g.From.Format(tf), g.To.Format(tf)))
+	}
+	return lines
+}
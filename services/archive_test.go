@@ -0,0 +1,120 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testManifest = `{
+  "version": 1,
+  "base_url": "https://example.com/daily",
+  "entries": [
+    { "date": "2025-01-05", "cycle": 0, "forecast": 6, "file": "gfs.0p25.2025010500.f006.grib2" },
+    { "date": "2025-01-03", "cycle": 18, "forecast": 6, "file": "gfs.0p25.2025010318.f006.grib2" },
+    { "date": "2025-01-04", "cycle": 6, "forecast": 6, "url": "https://mirror.example.com/x.grib2" },
+    { "date": "2025-01-10", "cycle": 12, "forecast": 6, "file": "gfs.0p25.2025011012.f006.grib2" }
+  ]
+}`
+
+func TestParseArchiveManifest(t *testing.T) {
+	m, err := ParseArchiveManifest(strings.NewReader(testManifest))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(m.Entries))
+
+	// sorted by valid time
+	e := &m.Entries[0]
+	assert.Equal(t, time.Date(2025, 1, 3, 18, 0, 0, 0, time.UTC), e.CycleTime())
+	assert.Equal(t, time.Date(2025, 1, 4, 0, 0, 0, 0, time.UTC), e.ValidTime())
+	assert.Equal(t, "https://example.com/daily/gfs.0p25.2025010318.f006.grib2", m.EntryUrl(e))
+	assert.Equal(t, "https://mirror.example.com/x.grib2", m.EntryUrl(&m.Entries[1]))
+
+	for _, s := range []string{
+		`{"version": 2, "entries": []}`,
+		`{"version": 1, "entries": [{"date": "2025-13-01", "cycle": 0, "file": "x"}]}`,
+		`{"version": 1, "entries": [{"date": "2025-01-01", "cycle": 24, "file": "x"}]}`,
+		`{"version": 1, "entries": [{"date": "2025-01-01", "cycle": 0}]}`,
+		`{"version": 1, "entries": [`,
+	} {
+		_, err := ParseArchiveManifest(strings.NewReader(s))
+		assert.Error(t, err, s)
+	}
+}
+
+func TestArchiveNearest(t *testing.T) {
+	m, err := ParseArchiveManifest(strings.NewReader(testManifest))
+	assert.NoError(t, err)
+	valid := func(e *ArchiveEntry) string { return e.ValidTime().Format("2006-01-02 15") }
+
+	assert.Equal(t, "2025-01-04 00", valid(m.Nearest(time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC))))
+	assert.Equal(t, "2025-01-10 18", valid(m.Nearest(time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC))))
+	assert.Equal(t, "2025-01-04 12", valid(m.Nearest(time.Date(2025, 1, 4, 10, 0, 0, 0, time.UTC))))
+	// a tie goes to the older one
+	assert.Equal(t, "2025-01-04 00", valid(m.Nearest(time.Date(2025, 1, 4, 6, 0, 0, 0, time.UTC))))
+	// exact
+	assert.Equal(t, "2025-01-05 06", valid(m.Nearest(time.Date(2025, 1, 5, 6, 0, 0, 0, time.UTC))))
+
+	assert.Nil(t, (&ArchiveManifest{}).Nearest(time.Now()))
+}
+
+func TestArchiveAvailability(t *testing.T) {
+	m, err := ParseArchiveManifest(strings.NewReader(testManifest))
+	assert.NoError(t, err)
+
+	first, last, gaps := m.Availability(archiveMaxGap)
+	assert.Equal(t, time.Date(2025, 1, 4, 0, 0, 0, 0, time.UTC), first)
+	assert.Equal(t, time.Date(2025, 1, 10, 18, 0, 0, 0, time.UTC), last)
+	assert.Equal(t, []ArchiveGap{{time.Date(2025, 1, 5, 6, 0, 0, 0, time.UTC),
+		time.Date(2025, 1, 10, 18, 0, 0, 0, time.UTC)}}, gaps)
+
+	_, _, gaps = m.Availability(12 * time.Hour)
+	assert.Equal(t, 2, len(gaps))
+
+	lines := m.Describe()
+	assert.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[0], "4 datasets from 2025-01-04 00z to 2025-01-10 18z, 1 gaps")
+
+	// from a file
+	fn := filepath.Join(t.TempDir(), "manifest.json")
+	os.WriteFile(fn, []byte(`{"version": 1, "entries": []}`), 0644)
+	m, err = LoadArchiveManifest(fn)
+	assert.NoError(t, err)
+	assert.Contains(t, m.Describe()[0], "is empty")
+	_, err = LoadArchiveManifest(filepath.Join(t.TempDir(), "none.json"))
+	assert.Error(t, err)
+}
+
+func TestArchiveManifestFailure(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/slow" {
+			time.Sleep(200 * time.Millisecond)
+		}
+		http.Error(w, "gone", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	// an unreachable mirror is not asked again for every download
+	t.Setenv("ARCHIVE_MANIFEST", srv.URL+"/manifest.json")
+	g := &gribService{Logger: newMockLogger()}
+	for k := 0; k < 3; k++ {
+		m, err := g.GetArchiveManifest()
+		assert.Error(t, err)
+		assert.Nil(t, m)
+	}
+	assert.Equal(t, int32(1), hits.Load())
+
+	// a stalled mirror times out
+	timeout := archiveClient.Timeout
+	archiveClient.Timeout = 50 * time.Millisecond
+	defer func() { archiveClient.Timeout = timeout }()
+	_, err := LoadArchiveManifest(srv.URL + "/slow")
+	assert.Error(t, err)
+}
@@ -0,0 +1,207 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xairline/xa-snow/utils/coast"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// reference: the serial extension this replaced
+func elsaOnTheShoresSerial(gribSnow *depthMap, layers []CoastLayer) DepthMap {
+	// the coast map is defined on the global 0.1° grid, regional grids must be part of it
+	g := &gribSnow.grid
+	di, dj, ok := g.offsetIn(&GlobalGrid)
+	if !ok {
+		gribSnow.Logger.Errorf("ElsaOnTheCoast: unsupported grid %s", g.String())
+		return gribSnow
+	}
+
+	new_dm := newDepthMapStorage(gribSnow.Logger, "Snow + Coast", gribSnow.grid, gribSnow.storage)
+	new_dm.interp = gribSnow.interp
+
+	n_extend := make([]int, len(layers))
+
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			sd := gribSnow.GetIdx(i, j)
+			sdn := new_dm.at(i, j) // may already be set by inland extension earlier
+			if sd > sdn {          // always maximize
+				new_dm.set(i, j, sd)
+			}
+
+			// the coast service wraps and clamps global indices itself
+			ci, cj := i+di, j+dj
+
+			for l := range layers {
+				cs, p := layers[l].Cs, &layers[l].Params
+				is_coast, dir_x, dir_y, _ := cs.IsCoast(ci, cj)
+				if !is_coast {
+					continue
+				}
+
+				min_sd := p.MinSd     // only go higher than this snow depth
+				max_step := p.MaxStep // to look for inland snow ~ 5 to 10 km / step
+				if sd <= min_sd {
+					// look for inland snow
+					inland_dist := 0
+					inland_sd := float32(0)
+					for k := 1; k <= max_step; k++ {
+						ii := i + k*dir_x
+						jj := j + k*dir_y
+
+						if k < max_step && cs.IsWater(ci+k*dir_x, cj+k*dir_y) { // if possible skip water
+							continue
+						}
+
+						tmp := gribSnow.GetIdx(ii, jj)
+						if tmp > sd && tmp > min_sd { // found snow
+							inland_dist = k
+							inland_sd = tmp
+							break
+						}
+					}
+
+					decay := p.Decay // snow depth decay per step
+					if inland_dist > 0 {
+						//g.Logger.Infof("Inland snow detected for (%d, %d) at dist %d, sd: %0.3f %0.3f",
+						//				 i, j, inland_dist, sd, inland_sd)
+
+						// use power law from inland point to coast line point
+						for k := inland_dist - 1; k >= 0; k-- {
+							inland_sd *= decay
+							if inland_sd < min_sd {
+								inland_sd = min_sd
+							}
+
+							// lon wraps, the poles are tricky so we just clamp,
+							// anyway it does not make a difference
+							x, y, ok := g.wrap(i+k*dir_x, j+k*dir_y)
+							if !ok {
+								continue // beyond a regional grid
+							}
+							new_dm.set(x, y, inland_sd)
+							n_extend[l]++
+						}
+					}
+				}
+				break
+			}
+		}
+	}
+
+	for l, n := range n_extend {
+		new_dm.Logger.Infof("Extended costal snow on %d grid points (%s)", n, layers[l].Params.Name)
+	}
+	return new_dm
+}
+
+// snow in the north and in the mountains of the synthetic world, bare coasts
+func coastalSnow(storage Storage) *depthMap {
+	m := newDepthMapStorage(newMockLogger(), "Snow", GlobalGrid, storage)
+	for i := 0; i < GlobalGrid.NLon; i++ {
+		for j := 0; j < GlobalGrid.NLat; j++ {
+			v := 0.3*math.Sin(float64(i)*0.02)*math.Cos(float64(j)*0.013) + float64(j-900)*0.0005
+			if v > 0.01 {
+				m.set(i, j, float32(v))
+			}
+		}
+	}
+	return m
+}
+
+// land and water swapped, so the sea shores are lake shores as well
+func invertedMask(img *image.NRGBA) *image.NRGBA {
+	inv := image.NewNRGBA(img.Rect)
+	for y := img.Rect.Min.Y; y < img.Rect.Max.Y; y++ {
+		for x := img.Rect.Min.X; x < img.Rect.Max.X; x++ {
+			c := color.NRGBA{0, 0, 0, 255}
+			if p := img.NRGBAAt(x, y); p.R == 0 && p.G == 0 && p.B == 0 {
+				c = color.NRGBA{255, 255, 255, 255}
+			}
+			inv.SetNRGBA(x, y, c)
+		}
+	}
+	return inv
+}
+
+func TestLonBands(t *testing.T) {
+	for _, reach := range []int{1, 3, 20, 40} {
+		bands := lonBands(n_iLon, reach)
+		n := len(bands) - 1
+		assert.Equal(t, 0, n%2, reach)
+		assert.Equal(t, n_iLon, bands[n])
+		for b := 0; b < n; b++ {
+			assert.Equal(t, 0, bands[b]%sparseBlockDim)
+			assert.GreaterOrEqual(t, bands[b+1]-bands[b], 2*(reach+sparseBlockDim))
+		}
+	}
+	assert.Equal(t, []int{0, 100}, lonBands(100, 3))
+}
+
+func TestElsaOnTheShoresParallel(t *testing.T) {
+	img := syntheticMask()
+	cm, err := coast.New(img, coast.DefaultOffset)
+	assert.NoError(t, err)
+	lakes, err := coast.New(invertedMask(img), coast.DefaultOffset)
+	assert.NoError(t, err)
+	lake := CoastParamSets["fjord"]
+	layers := []CoastLayer{{Cs: cm, Params: DefaultCoastParams}, {Cs: lakes, Params: lake}}
+
+	for _, storage := range []Storage{StorageFloat, StorageQuantized, StorageSparse} {
+		in := coastalSnow(storage)
+		ref := elsaOnTheShoresSerial(in, layers).(*depthMap)
+		out := ElsaOnTheShores(in, layers).(*depthMap)
+
+		// where extensions overlap the serial version took the last one, now it's the deepest
+		n_diff, n_lower, n_coast := 0, 0, 0
+		for i := 0; i < n_iLon; i++ {
+			for j := 0; j < n_iLat; j++ {
+				if out.at(i, j) != ref.at(i, j) {
+					n_diff++
+				}
+				if out.at(i, j) < ref.at(i, j) {
+					n_lower++
+				}
+				if out.at(i, j) != in.at(i, j) {
+					n_coast++
+				}
+			}
+		}
+		assert.Greater(t, n_coast, 1000, storage.String())
+		assert.Less(t, n_diff, n_coast/100, storage.String())
+		assert.Equal(t, 0, n_lower, storage.String())
+	}
+}
+
+// go test ./services -run '^$' -bench ElsaOnTheShores
+func BenchmarkElsaOnTheShores(b *testing.B) {
+	dir := b.TempDir()
+	writeSyntheticMask(b, dir)
+	cs, err := NewCoastService(newMockLogger(), dir)
+	assert.NoError(b, err)
+	ccs, err := NewCgoCoastService(newMockLogger(), dir)
+	assert.NoError(b, err)
+	in := coastalSnow(StorageFloat)
+
+	b.Run("serial-cgo", func(b *testing.B) {
+		layers := []CoastLayer{{Cs: ccs, Params: DefaultCoastParams}}
+		for i := 0; i < b.N; i++ {
+			elsaOnTheShoresSerial(in, layers)
+		}
+	})
+	b.Run("serial", func(b *testing.B) {
+		layers := []CoastLayer{{Cs: cs, Params: DefaultCoastParams}}
+		for i := 0; i < b.N; i++ {
+			elsaOnTheShoresSerial(in, layers)
+		}
+	})
+	b.Run("sparse", func(b *testing.B) {
+		layers := []CoastLayer{{Cs: cs, Params: DefaultCoastParams}}
+		for i := 0; i < b.N; i++ {
+			ElsaOnTheShores(in, layers)
+		}
+	})
+}
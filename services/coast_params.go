@@ -0,0 +1,101 @@
+package services
+
+import (
+	"fmt"
+	"github.com/xairline/xa-snow/utils/coast"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Parameters of the coastal snow extension (ElsaOnTheCoast). SNOW_COAST selects a named set and
+// may override single values: "fjord" or "default:max_step=4,decay=0.85".
+type CoastParams struct {
+	Name    string
+	MinSd   float32 // m, coast points with less snow get inland snow
+	MaxStep int     // grid points to look for inland snow, ~5 to 10 km / step
+	Decay   float32 // snow depth decay per step towards the coast
+	Offset  int     // grid points the water mask is shifted against the snow grid
+}
+
+var DefaultCoastParams = CoastParams{Name: "default", MinSd: 0.02, MaxStep: 3, Decay: 0.8, Offset: coast.DefaultOffset}
+
+// Starting points for regional tuning: fjords are narrow and steep so inland snow is further away
+// and reaches down to the water, the flat shores of big lakes get less.
+var CoastParamSets = map[string]CoastParams{
+	"default": DefaultCoastParams,
+	"fjord":   {Name: "fjord", MinSd: 0.02, MaxStep: 5, Decay: 0.9, Offset: coast.DefaultOffset},
+	"lakes":   {Name: "lakes", MinSd: 0.01, MaxStep: 2, Decay: 0.7, Offset: coast.DefaultOffset},
+}
+
+func ParseCoastParams(spec string) (CoastParams, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return DefaultCoastParams, nil
+	}
+
+	name, overrides, _ := strings.Cut(spec, ":")
+	p, ok := CoastParamSets[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		names := make([]string, 0, len(CoastParamSets))
+		for n := range CoastParamSets {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return DefaultCoastParams, fmt.Errorf("unknown coast parameter set '%s', use one of %s", name, strings.Join(names, ", "))
+	}
+
+	for _, kv := range strings.Split(overrides, ",") {
+		if strings.TrimSpace(kv) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return DefaultCoastParams, fmt.Errorf("'%s': expected key=value", kv)
+		}
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+
+		var err error
+		switch k {
+		case "min_sd":
+			p.MinSd, err = argFloat(v)
+		case "decay":
+			p.Decay, err = argFloat(v)
+		case "max_step":
+			p.MaxStep, err = strconv.Atoi(v)
+		case "offset":
+			p.Offset, err = strconv.Atoi(v)
+		default:
+			return DefaultCoastParams, fmt.Errorf("'%s': unknown parameter, use min_sd, max_step, decay or offset", kv)
+		}
+		if err != nil {
+			return DefaultCoastParams, fmt.Errorf("'%s': invalid value", kv)
+		}
+	}
+
+	if err := p.Check(); err != nil {
+		return DefaultCoastParams, err
+	}
+	return p, nil
+}
+
+func (p CoastParams) Check() error {
+	switch {
+	case p.MinSd < 0:
+		return fmt.Errorf("min_sd must be >= 0")
+	case p.MaxStep < 1 || p.MaxStep > 20:
+		return fmt.Errorf("max_step must be in 1..20")
+	case p.Decay <= 0 || p.Decay > 1:
+		return fmt.Errorf("decay must be in (0, 1]")
+	case p.Offset < 0 || p.Offset > 10:
+		return fmt.Errorf("offset must be in 0..10")
+	}
+	return nil
+}
+
+// all values, can be parsed again
+func (p CoastParams) String() string {
+	return fmt.Sprintf("%s:min_sd=%s,max_step=%d,decay=%s,offset=%d", p.Name,
+		strconv.FormatFloat(float64(p.MinSd), 'g', -1, 32), p.MaxStep,
+		strconv.FormatFloat(float64(p.Decay), 'g', -1, 32), p.Offset)
+}
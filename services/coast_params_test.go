@@ -0,0 +1,135 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xairline/xa-snow/utils/coast"
+	"testing"
+)
+
+func TestParseCoastParams(t *testing.T) {
+	p, err := ParseCoastParams("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultCoastParams, p)
+
+	p, err = ParseCoastParams(" Fjord ")
+	assert.NoError(t, err)
+	assert.Equal(t, CoastParamSets["fjord"], p)
+
+	p, err = ParseCoastParams("default:max_step=4, decay=0.85,min_sd=0.01,offset=2")
+	assert.NoError(t, err)
+	assert.Equal(t, CoastParams{Name: "default", MinSd: 0.01, MaxStep: 4, Decay: 0.85, Offset: 2}, p)
+
+	// round trip
+	q, err := ParseCoastParams(p.String())
+	assert.NoError(t, err)
+	assert.Equal(t, p, q)
+
+	for _, spec := range []string{"bogus", "default:max_step", "default:foo=1", "default:decay=x",
+		"default:decay=0", "default:max_step=30", "lakes:offset=11", "default:min_sd=-1"} {
+		p, err := ParseCoastParams(spec)
+		assert.Error(t, err, spec)
+		assert.Equal(t, DefaultCoastParams, p, spec)
+	}
+}
+
+func TestElsaOnTheCoastParams(t *testing.T) {
+	// the coast is at i = 29, inland snow starts 3 points east of it
+	in := stripedSnow()
+	sd := in.GetIdx(32, 0)
+	assert.Greater(t, sd, float32(0.02))
+
+	out := ElsaOnTheCoast(in, stubCoast{}, DefaultCoastParams)
+	assert.InDelta(t, sd*0.8*0.8*0.8, out.GetIdx(29, 0), 1e-6)
+	assert.InDelta(t, sd*0.8, out.GetIdx(31, 0), 1e-6)
+
+	p := DefaultCoastParams
+	p.Decay = 0.9
+	out = ElsaOnTheCoast(in, stubCoast{}, p)
+	assert.InDelta(t, sd*0.9*0.9*0.9, out.GetIdx(29, 0), 1e-6)
+
+	// inland snow is too far away
+	out = ElsaOnTheCoast(in, stubCoast{}, CoastParamSets["lakes"])
+	assert.Equal(t, float32(0), out.GetIdx(29, 0))
+}
+
+func TestSnapshotProvenance(t *testing.T) {
+	s := &SnowSnapshot{Source: "gfs.grib2", Pipeline: "dem,coast", Coast: CoastParamSets["fjord"]}
+	assert.Equal(t, "source: gfs.grib2, cycle: unknown, pipeline: dem,coast, "+
+		"coast: fjord:min_sd=0.02,max_step=5,decay=0.9,offset=3, lakes: off, sea ice: off, noise: off", s.Provenance())
+
+	s.Lakes = CoastParamSets["lakes"]
+	assert.Contains(t, s.Provenance(), "lakes: lakes:min_sd=0.01,max_step=2,decay=0.7,offset=3,")
+}
+
+// a lake at i = 60 .. 64, the shore looks east
+type stubLake struct{}
+
+func (stubLake) IsWater(i, j int) bool {
+	return i >= 60 && i < 65
+}
+
+func (c stubLake) IsLand(i, j int) bool {
+	return !c.IsWater(i, j)
+}
+
+func (c stubLake) IsCoast(i, j int) (bool, int, int, int) {
+	if i == 64 || i == 29 { // the ocean coast as well
+		return true, 1, 0, 0
+	}
+	return false, 0, 0, 0
+}
+
+func (c stubLake) CoastPoints() []coast.Point {
+	return scanCoastPoints(c)
+}
+
+func TestElsaOnTheShores(t *testing.T) {
+	in := stripedSnow()
+	for i := 60; i < 67; i++ {
+		in.set(i, 0, 0)
+	}
+	sd := in.GetIdx(67, 0)
+	assert.Greater(t, sd, float32(0.02))
+
+	// the lake shore is ignored without the lake layer
+	out := ElsaOnTheCoast(in, stubCoast{}, DefaultCoastParams)
+	assert.Equal(t, float32(0), out.GetIdx(64, 0))
+
+	lake := DefaultCoastParams
+	lake.Name, lake.Decay = "lake", 0.9
+	out = ElsaOnTheShores(in, []CoastLayer{{Cs: stubCoast{}, Params: DefaultCoastParams}, {Cs: stubLake{}, Params: lake}})
+	assert.InDelta(t, sd*0.9*0.9*0.9, out.GetIdx(64, 0), 1e-6)
+	assert.InDelta(t, sd*0.9, out.GetIdx(66, 0), 1e-6)
+
+	// ocean coast points are handled by the ocean layer
+	sd = in.GetIdx(32, 0)
+	assert.InDelta(t, sd*0.8*0.8*0.8, out.GetIdx(29, 0), 1e-6)
+
+	// and the lake parameters
+	lake.MaxStep = 2
+	out = ElsaOnTheShores(in, []CoastLayer{{Cs: stubCoast{}, Params: DefaultCoastParams}, {Cs: stubLake{}, Params: lake}})
+	assert.Equal(t, float32(0), out.GetIdx(64, 0))
+}
+
+func TestLakesInPipeline(t *testing.T) {
+	in := stripedSnow()
+	for i := 60; i < 67; i++ {
+		in.set(i, 0, 0)
+	}
+	p, err := ParsePipeline("coast")
+	assert.NoError(t, err)
+
+	// the default lake parameters don't look far enough
+	pc := &PipelineContext{Logger: newMockLogger(), Lakes: stubLake{}, Maps: map[string]DepthMap{"raw": in}}
+	out, err := p.Run(pc, in)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(0), out.GetIdx(64, 0))
+
+	pc.LakeCoast = DefaultCoastParams
+	out, err = p.Run(pc, in)
+	assert.NoError(t, err)
+	assert.Greater(t, out.GetIdx(64, 0), float32(0))
+
+	_, err = p.Run(&PipelineContext{Logger: newMockLogger()}, in)
+	assert.Error(t, err)
+}
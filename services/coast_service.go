@@ -0,0 +1,102 @@
+package services
+
+import (
+	"fmt"
+	"github.com/xairline/xa-snow/utils/coast"
+	"github.com/xairline/xa-snow/utils/logger"
+	"path/filepath"
+	"strings"
+)
+
+// water, land and coast on the global 0.1° grid, lon wraps and lat is clamped
+type CoastService interface {
+	IsWater(i, j int) bool
+	IsLand(i, j int) bool
+	IsCoast(i, j int) (bool, int, int, int) // -> yes_no, dir_x, dir_y, grid angle
+
+	// all coast points sorted by i, then j, not to be modified
+	CoastPoints() []coast.Point
+}
+
+// implemented by coast services that can hand out a Go coast map
+type coastMapper interface {
+	coastMap() *coast.Map
+}
+
+// cs for lookups in bulk, a Go coast map if there is one
+func fastCoast(cs CoastService) CoastService {
+	if cm, ok := cs.(coastMapper); ok {
+		return cm.coastMap()
+	}
+	return cs
+}
+
+type goCoastService struct {
+	*coast.Map
+	logger      logger.Logger
+	name        string // for the log
+	path, cache string // cache of the default offset
+	cached      bool   // loaded from the cache
+}
+
+// coast map of the ESA water mask in dir, classified in Go or taken from the cache next to it
+func NewCoastService(logger logger.Logger, dir string) (CoastService, error) {
+	cs, err := newGoCoastService(logger, "Coast", filepath.Join(dir, coast.FileName),
+		filepath.Join(dir, coast.CacheName), coast.DefaultOffset)
+	if err != nil {
+		return nil, err // not a nil *goCoastService, callers check for nil
+	}
+	return cs, nil
+}
+
+// Shores of inland water from a mask like the ocean one, a PNG in plate carrée at 0.1° with black
+// for water. The mask may include the oceans, coast points of the ocean map take precedence.
+// The classified map is cached next to the PNG.
+func NewLakeService(logger logger.Logger, path string, offset int) (CoastService, error) {
+	ls, err := newLakeService(logger, path, offset)
+	if err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+func newLakeService(logger logger.Logger, path string, offset int) (*goCoastService, error) {
+	return newGoCoastService(logger, "Lake", path, strings.TrimSuffix(path, filepath.Ext(path))+".wmap", offset)
+}
+
+func newGoCoastService(logger logger.Logger, name, path, cache string, offset int) (*goCoastService, error) {
+	cm, cached, err := coast.LoadCached(path, offsetCache(cache, offset), offset)
+	if err != nil {
+		return nil, err
+	}
+	if n, m := cm.Size(); n != n_iLon || m != n_iLat-1 {
+		return nil, fmt.Errorf("'%s': water mask is %dx%d, expected %dx%d", path, n, m, n_iLon, n_iLat-1)
+	}
+
+	water, land, n_coast := cm.Count()
+	logger.Infof("%s map loaded, offset: %d, cached: %t, water: %d, land: %d, coast: %d",
+		name, offset, cached, water, land, n_coast)
+	return &goCoastService{Map: cm, logger: logger, name: name, path: path, cache: cache, cached: cached}, nil
+}
+
+// one cache file per offset, so a tuned offset doesn't evict the default one,
+// e.g. lakes.wmap -> lakes-o4.wmap
+func offsetCache(cache string, offset int) string {
+	if offset == coast.DefaultOffset {
+		return cache
+	}
+	ext := filepath.Ext(cache)
+	return fmt.Sprintf("%s-o%d%s", strings.TrimSuffix(cache, ext), offset, ext)
+}
+
+func (cs *goCoastService) coastMap() *coast.Map {
+	return cs.Map
+}
+
+// the same mask classified with another offset
+func (cs *goCoastService) withOffset(offset int) (*goCoastService, error) {
+	if offset == cs.Offset() {
+		return cs, nil
+	}
+	return newGoCoastService(cs.logger, cs.name, cs.path, cs.cache, offset)
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xairline/xa-snow/utils/coast"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// wavy continents and random islands
+func syntheticMask() *image.NRGBA {
+	rnd := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, n_iLon, n_iLat-1))
+	for y := 0; y < n_iLat-1; y++ {
+		for x := 0; x < n_iLon; x++ {
+			v := math.Sin(float64(x)*0.011) + math.Cos(float64(y)*0.017+math.Sin(float64(x)*0.003))
+			c := color.NRGBA{0, 0, 0, 255}
+			if v > 0.3 || rnd.Intn(500) == 0 {
+				c = color.NRGBA{20, 120, 40, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func writeSyntheticMask(t testing.TB, dir string) {
+	f, err := os.Create(filepath.Join(dir, coast.FileName))
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(f, syntheticMask()))
+	f.Close()
+}
+
+// the Go and the C++ coast maps classify a synthetic world in the same way
+func TestCoastServiceMatchesCgo(t *testing.T) {
+	dir := t.TempDir()
+	cs, err := NewCoastService(newMockLogger(), dir)
+	assert.Error(t, err)
+	assert.Nil(t, cs)
+	ls, err := NewLakeService(newMockLogger(), filepath.Join(dir, "lakes.png"), coast.DefaultOffset)
+	assert.Error(t, err)
+	assert.Nil(t, ls)
+
+	writeSyntheticMask(t, dir)
+
+	cs, err = NewCoastService(newMockLogger(), dir)
+	assert.NoError(t, err)
+	ccs, err := NewCgoCoastService(newMockLogger(), dir)
+	assert.NoError(t, err)
+
+	n_diff, n_coast := 0, 0
+	for i := 0; i < n_iLon; i++ {
+		for j := 0; j < n_iLat-1; j++ {
+			yes, dx, dy, dir := cs.IsCoast(i, j)
+			cyes, cdx, cdy, cdir := ccs.IsCoast(i, j)
+			if yes {
+				n_coast++
+			}
+			if yes != cyes || dx != cdx || dy != cdy || dir != cdir ||
+				cs.IsWater(i, j) != ccs.IsWater(i, j) || cs.IsLand(i, j) != ccs.IsLand(i, j) {
+				n_diff++
+			}
+		}
+	}
+	assert.Greater(t, n_coast, 10000)
+	assert.Equal(t, 0, n_diff)
+
+	// wrapped and clamped
+	assert.Equal(t, ccs.IsWater(-5, 2000), cs.IsWater(-5, 2000))
+	assert.Equal(t, ccs.IsLand(n_iLon+7, 900), cs.IsLand(n_iLon+7, 900))
+
+	// another offset shifts the map
+	gcs := cs.(*goCoastService)
+	same, err := gcs.withOffset(coast.DefaultOffset)
+	assert.NoError(t, err)
+	assert.Same(t, gcs, same)
+	cs2, err := gcs.withOffset(coast.DefaultOffset - 1)
+	assert.NoError(t, err)
+	assert.Equal(t, coast.DefaultOffset-1, cs2.Offset())
+	for i := 0; i < n_iLon; i += 7 {
+		assert.Equal(t, cs.IsLand(i, 900), cs2.IsLand(i+1, 901))
+	}
+}
+
+// a tuned offset has its own cache file, the default one stays valid
+func TestCoastServiceOffsetCache(t *testing.T) {
+	dir := t.TempDir()
+	writeSyntheticMask(t, dir)
+
+	for k, cached := range []bool{false, true} {
+		cs, err := NewCoastService(newMockLogger(), dir)
+		assert.NoError(t, err)
+		assert.Equal(t, cached, cs.(*goCoastService).cached, "default, run %d", k)
+
+		cs4, err := cs.(*goCoastService).withOffset(4)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, cs4.Offset())
+		assert.Equal(t, cached, cs4.cached, "offset 4, run %d", k)
+	}
+	assert.Equal(t, filepath.Join(dir, "lakes-o4.wmap"), offsetCache(filepath.Join(dir, "lakes.wmap"), 4))
+	_, err := os.Stat(filepath.Join(dir, strings.TrimSuffix(coast.CacheName, ".wmap")+"-o4.wmap"))
+	assert.NoError(t, err)
+}
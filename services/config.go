@@ -0,0 +1,35 @@
+package services
+
+import (
+	"github.com/xairline/xa-snow/utils/logger"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// numeric settings from xa-snow.prf, the default is used if unset or invalid
+func envFloat(logger logger.Logger, name string, def float32) float32 {
+	s := strings.TrimSpace(os.Getenv(name))
+	if s == "" {
+		return def
+	}
+	v, err := strconv.ParseFloat(s, 32)
+	if err != nil {
+		logger.Errorf("%s: invalid value '%s', using %0.3f", name, s, def)
+		return def
+	}
+	return float32(v)
+}
+
+func envInt(logger logger.Logger, name string, def int) int {
+	s := strings.TrimSpace(os.Getenv(name))
+	if s == "" {
+		return def
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		logger.Errorf("%s: invalid value '%s', using %d", name, s, def)
+		return def
+	}
+	return v
+}
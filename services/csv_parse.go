@@ -0,0 +1,329 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// A global 0.1° csv is ~6.5M lines. The file is read in chunks that end on a line boundary and
+// the chunks are parsed by one goroutine per CPU straight into the map. Buffers are recycled,
+// so apart from a few chunk buffers parsing does not allocate.
+const csvChunkSize = 1 << 20
+
+type csvChunk struct {
+	buf   []byte
+	first bool // starts at the beginning of the file, the first line may be a header
+}
+
+type csvLoader struct {
+	m       *depthMap
+	covered []uint64     // bit set of grid points written
+	lock    sync.Mutex   // for stores that can't be written concurrently
+	reports []LoadReport // one per worker
+}
+
+func newCsvLoader(m *depthMap) *csvLoader {
+	n := runtime.GOMAXPROCS(0)
+	p := &csvLoader{m: m, covered: make([]uint64, (m.grid.Size()+63)/64), reports: make([]LoadReport, n)}
+	for i := range p.reports {
+		p.reports[i].Min = float32(math.Inf(1))
+		p.reports[i].Max = float32(math.Inf(-1))
+	}
+	return p
+}
+
+func (p *csvLoader) run(rd io.Reader) error {
+	n_workers := len(p.reports)
+	free := make(chan []byte, 2*n_workers)
+	for i := 0; i < cap(free); i++ {
+		free <- make([]byte, csvChunkSize)
+	}
+	work := make(chan csvChunk, n_workers)
+
+	var wg sync.WaitGroup
+	for w := 0; w < n_workers; w++ {
+		wg.Add(1)
+		go func(r *LoadReport) {
+			defer wg.Done()
+			for c := range work {
+				p.parseChunk(c, r)
+				free <- c.buf[:cap(c.buf)]
+			}
+		}(&p.reports[w])
+	}
+
+	err := p.split(rd, free, work)
+	close(work)
+	wg.Wait()
+	return err
+}
+
+// cut the input into chunks on line boundaries
+func (p *csvLoader) split(rd io.Reader, free chan []byte, work chan<- csvChunk) error {
+	buf := <-free
+	tail := 0 // incomplete line carried over from the previous chunk
+	first := true
+	for {
+		n, err := io.ReadFull(rd, buf[tail:])
+		end := tail + n
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			return err
+		}
+
+		cut := end
+		if !eof {
+			cut = bytes.LastIndexByte(buf[:end], '\n') + 1
+			if cut == 0 {
+				// line longer than the buffer, the old buffer is dropped so the number in use stays constant
+				nb := make([]byte, 2*len(buf))
+				copy(nb, buf[:end])
+				buf, tail = nb, end
+				continue
+			}
+		}
+
+		next := <-free
+		rest := end - cut
+		if rest >= len(next) {
+			next = make([]byte, len(buf))
+		}
+		copy(next, buf[cut:end])
+		if cut > 0 {
+			work <- csvChunk{buf: buf[:cut], first: first}
+			first = false
+		} else {
+			free <- buf
+		}
+		buf, tail = next, rest
+
+		if eof {
+			free <- buf
+			return nil
+		}
+	}
+}
+
+func (p *csvLoader) parseChunk(c csvChunk, r *LoadReport) {
+	m := p.m
+	concurrent := m.val.concurrentSet()
+	first := c.first
+
+	b := c.buf
+	for len(b) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line, b = b[:i], b[i+1:]
+		} else {
+			line, b = b, nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		lon, lat, value, ok := parseCsvLine(line)
+		if first {
+			first = false
+			if !ok {
+				continue // header
+			}
+		}
+
+		r.Rows++
+		if !ok || !(lat >= -90 && lat <= 90 && lon >= -180 && lon <= 360) {
+			r.Rejected++
+			continue
+		}
+
+		x, y, ok := m.grid.Idx(float32(lon), float32(lat))
+		if !ok {
+			r.OffGrid++
+			continue // not on our grid
+		}
+
+		v := float32(value)
+		switch {
+		case value >= gribUndefined:
+			r.Undef++
+			v = 0
+		case math.IsNaN(value):
+			r.NaN++
+			v = 0
+		case v < 0:
+			r.Negative++
+			v = 0
+		default:
+			r.Min = min(r.Min, v)
+			r.Max = max(r.Max, v)
+		}
+
+		if setBit(p.covered, x*m.grid.NLat+y) {
+			r.Cells++
+		}
+		if concurrent {
+			m.set(x, y, v)
+		} else {
+			p.lock.Lock()
+			m.set(x, y, v)
+			p.lock.Unlock()
+		}
+	}
+}
+
+// sum of the workers' reports
+func (p *csvLoader) report() *LoadReport {
+	r := &LoadReport{Min: float32(math.Inf(1)), Max: float32(math.Inf(-1))}
+	for _, w := range p.reports {
+		r.Rows += w.Rows
+		r.Rejected += w.Rejected
+		r.OffGrid += w.OffGrid
+		r.Cells += w.Cells
+		r.Undef += w.Undef
+		r.NaN += w.NaN
+		r.Negative += w.Negative
+		r.Min = min(r.Min, w.Min)
+		r.Max = max(r.Max, w.Max)
+	}
+	if r.Min > r.Max {
+		r.Min, r.Max = 0, 0
+	}
+	return r
+}
+
+// true if the bit was not set before
+func setBit(bits []uint64, k int) bool {
+	w := &bits[k/64]
+	mask := uint64(1) << (k % 64)
+	for {
+		old := atomic.LoadUint64(w)
+		if old&mask != 0 {
+			return false
+		}
+		if atomic.CompareAndSwapUint64(w, old, old|mask) {
+			return true
+		}
+	}
+}
+
+// "lon, lat, value[, ...]"
+func parseCsvLine(line []byte) (lon, lat, value float64, ok bool) {
+	var f [3][]byte
+	for k := range f {
+		i := bytes.IndexByte(line, ',')
+		if i < 0 {
+			if k < 2 {
+				return 0, 0, 0, false
+			}
+			f[k], line = line, nil
+		} else {
+			f[k], line = line[:i], line[i+1:]
+		}
+	}
+
+	var ok1, ok2, ok3 bool
+	lon, ok1 = parseFloatBytes(f[0])
+	lat, ok2 = parseFloatBytes(f[1])
+	value, ok3 = parseFloatBytes(f[2])
+	return lon, lat, value, ok1 && ok2 && ok3
+}
+
+// exactly representable powers of 10
+var pow10tab = [...]float64{
+	1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11,
+	1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19, 1e20, 1e21, 1e22,
+}
+
+// Allocation free parser for the plain decimal numbers wgrib2 writes, anything else (NaN, Inf)
+// goes to strconv. Results are exact for up to 15 significant digits and |exp| <= 22, otherwise
+// within a few ulp which is far below float32 resolution.
+func parseFloatBytes(b []byte) (float64, bool) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return 0, false
+	}
+
+	i := 0
+	neg := false
+	if b[0] == '-' || b[0] == '+' {
+		neg = b[0] == '-'
+		i++
+	}
+
+	var mant uint64
+	exp, nd := 0, 0 // nd: significant digits in mant
+	digits := false
+	for ; i < len(b) && '0' <= b[i] && b[i] <= '9'; i++ {
+		digits = true
+		if nd < 19 {
+			mant = mant*10 + uint64(b[i]-'0')
+			if mant != 0 {
+				nd++
+			}
+		} else {
+			exp++
+		}
+	}
+	if i < len(b) && b[i] == '.' {
+		i++
+		for ; i < len(b) && '0' <= b[i] && b[i] <= '9'; i++ {
+			digits = true
+			if nd < 19 {
+				mant = mant*10 + uint64(b[i]-'0')
+				if mant != 0 {
+					nd++
+				}
+				exp--
+			}
+		}
+	}
+	if !digits {
+		v, err := strconv.ParseFloat(string(b), 64)
+		return v, err == nil
+	}
+
+	if i < len(b) && (b[i] == 'e' || b[i] == 'E') {
+		i++
+		eneg := false
+		if i < len(b) && (b[i] == '+' || b[i] == '-') {
+			eneg = b[i] == '-'
+			i++
+		}
+		if i == len(b) {
+			return 0, false
+		}
+		e := 0
+		for ; i < len(b) && '0' <= b[i] && b[i] <= '9'; i++ {
+			if e < 10000 {
+				e = e*10 + int(b[i]-'0')
+			}
+		}
+		if eneg {
+			e = -e
+		}
+		exp += e
+	}
+	if i != len(b) {
+		return 0, false
+	}
+
+	f := float64(mant)
+	switch {
+	case mant == 0:
+	case exp >= 0 && exp < len(pow10tab) && mant < 1<<53:
+		f *= pow10tab[exp]
+	case exp < 0 && -exp < len(pow10tab) && mant < 1<<53:
+		f /= pow10tab[-exp]
+	default:
+		f *= math.Pow10(exp)
+	}
+	if neg {
+		f = -f
+	}
+	return f, true
+}
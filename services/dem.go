@@ -0,0 +1,392 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"github.com/xairline/xa-snow/utils/logger"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Mean terrain elevation per grid point, aggregated from a (much finer) DEM.
+// Raw heightmaps are read in ESRI BIL format with a .hdr file, as e.g. GTOPO30 is distributed.
+const elevNoData = math.MinInt16
+
+type ElevationMap struct {
+	grid Grid
+	val  []int16 // [iLon * NLat + iLat] in m, elevNoData where the DEM has no data (ocean)
+}
+
+func (e *ElevationMap) Grid() *Grid {
+	return &e.grid
+}
+
+// elevation in m, ok is false for points without data
+func (e *ElevationMap) GetIdx(iLon, iLat int) (float32, bool) {
+	iLon, iLat, ok := e.grid.wrap(iLon, iLat)
+	if !ok {
+		return 0, false
+	}
+	v := e.val[iLon*e.grid.NLat+iLat]
+	if v == elevNoData {
+		return 0, false
+	}
+	return float32(v), true
+}
+
+// nearest grid point
+func (e *ElevationMap) Get(lon, lat float32) (float32, bool) {
+	iLon, iLat, ok := e.grid.Idx(lon, lat)
+	if !ok {
+		return 0, false
+	}
+	return e.GetIdx(iLon, iLat)
+}
+
+type bilHeader struct {
+	nRows, nCols  int
+	nBits         int
+	float         bool
+	byteOrder     binary.ByteOrder
+	ulx, uly      float64 // center of the upper left pixel
+	xdim, ydim    float64
+	noData        float64
+	hasNoData     bool
+	skipBytes     int64
+	totalRowBytes int
+}
+
+func readBilHeader(hdr_name string) (*bilHeader, error) {
+	f, err := os.Open(hdr_name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := &bilHeader{nBits: 16, byteOrder: binary.LittleEndian}
+	has_ulx, has_uly := false, false // 0 is a valid corner, so they must be given
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		key := strings.ToUpper(fields[0])
+		val := fields[1]
+		var err error
+		switch key {
+		case "BYTEORDER":
+			if strings.ToUpper(val)[0] == 'M' {
+				h.byteOrder = binary.BigEndian
+			}
+		case "LAYOUT":
+			if strings.ToUpper(val) != "BIL" {
+				return nil, fmt.Errorf("%s: unsupported layout '%s'", hdr_name, val)
+			}
+		case "NBANDS":
+			if val != "1" {
+				return nil, fmt.Errorf("%s: only single band files are supported", hdr_name)
+			}
+		case "NROWS":
+			h.nRows, err = strconv.Atoi(val)
+		case "NCOLS":
+			h.nCols, err = strconv.Atoi(val)
+		case "NBITS":
+			h.nBits, err = strconv.Atoi(val)
+		case "PIXELTYPE":
+			h.float = strings.ToUpper(val) == "FLOAT"
+		case "ULXMAP":
+			h.ulx, err = strconv.ParseFloat(val, 64)
+			has_ulx = true
+		case "ULYMAP":
+			h.uly, err = strconv.ParseFloat(val, 64)
+			has_uly = true
+		case "XDIM":
+			h.xdim, err = strconv.ParseFloat(val, 64)
+		case "YDIM":
+			h.ydim, err = strconv.ParseFloat(val, 64)
+		case "NODATA":
+			h.noData, err = strconv.ParseFloat(val, 64)
+			h.hasNoData = true
+		case "SKIPBYTES":
+			h.skipBytes, err = strconv.ParseInt(val, 10, 64)
+		case "TOTALROWBYTES":
+			h.totalRowBytes, err = strconv.Atoi(val)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid %s: %w", hdr_name, key, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	if h.nRows <= 0 || h.nCols <= 0 || h.xdim <= 0 || h.ydim <= 0 || !has_ulx || !has_uly {
+		return nil, fmt.Errorf("%s: incomplete header", hdr_name)
+	}
+	if !(h.nBits == 16 && !h.float) && !(h.nBits == 32 && h.float) {
+		return nil, fmt.Errorf("%s: only 16 bit integer or 32 bit float data is supported", hdr_name)
+	}
+	if h.totalRowBytes == 0 {
+		h.totalRowBytes = h.nCols * h.nBits / 8
+	}
+	return h, nil
+}
+
+// data file next to the header, GTOPO30 uses .DEM instead of .bil
+func bilDataFile(hdr_name string) (string, error) {
+	base := strings.TrimSuffix(hdr_name, filepath.Ext(hdr_name))
+	for _, ext := range []string{".bil", ".BIL", ".dem", ".DEM"} {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext, nil
+		}
+	}
+	return "", fmt.Errorf("no data file for '%s'", hdr_name)
+}
+
+// aggregate DEM files into mean elevations on grid
+// path is a .hdr file or a directory with .hdr files (tiles)
+func LoadElevationMap(logger logger.Logger, path string, grid Grid) (*ElevationMap, error) {
+	var hdrs []string
+	if st, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if st.IsDir() {
+		for _, pat := range []string{"*.hdr", "*.HDR"} {
+			m, _ := filepath.Glob(filepath.Join(path, pat))
+			hdrs = append(hdrs, m...)
+		}
+		if len(hdrs) == 0 {
+			return nil, fmt.Errorf("no .hdr files in '%s'", path)
+		}
+	} else {
+		hdrs = []string{path}
+	}
+
+	sum := make([]float32, grid.Size())
+	cnt := make([]uint16, grid.Size())
+
+	for _, hdr := range hdrs {
+		if err := aggregateBil(hdr, &grid, sum, cnt); err != nil {
+			return nil, err
+		}
+		logger.Infof("DEM '%s' loaded", hdr)
+	}
+
+	e := &ElevationMap{grid: grid, val: make([]int16, grid.Size())}
+	n := 0
+	for i := range e.val {
+		if cnt[i] == 0 {
+			e.val[i] = elevNoData
+			continue
+		}
+		e.val[i] = int16(math.Round(float64(sum[i] / float32(cnt[i]))))
+		n++
+	}
+	logger.Infof("Elevation map: %d of %d grid points with data", n, len(e.val))
+	return e, nil
+}
+
+func aggregateBil(hdr_name string, grid *Grid, sum []float32, cnt []uint16) error {
+	h, err := readBilHeader(hdr_name)
+	if err != nil {
+		return err
+	}
+
+	data_name, err := bilDataFile(hdr_name)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(data_name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(h.skipBytes, io.SeekStart); err != nil {
+		return err
+	}
+
+	rd := bufio.NewReaderSize(f, 1<<20)
+	row := make([]byte, h.totalRowBytes)
+	bpp := h.nBits / 8
+
+	// grid index of each column, computed once
+	col_iLon := make([]int, h.nCols)
+	for c := 0; c < h.nCols; c++ {
+		lon := float32(h.ulx + float64(c)*h.xdim)
+		col_iLon[c], _, _ = grid.Idx(lon, grid.Lat0)
+	}
+
+	for r := 0; r < h.nRows; r++ {
+		if _, err := io.ReadFull(rd, row); err != nil {
+			return fmt.Errorf("%s: row %d: %w", data_name, r, err)
+		}
+
+		lat := float32(h.uly - float64(r)*h.ydim)
+		_, iLat, _ := grid.Idx(grid.Lon0, lat)
+		if iLat < 0 || iLat >= grid.NLat {
+			continue
+		}
+
+		for c := 0; c < h.nCols; c++ {
+			iLon := col_iLon[c]
+			if iLon < 0 || iLon >= grid.NLon {
+				continue
+			}
+
+			var v float64
+			b := row[c*bpp:]
+			if h.float {
+				v = float64(math.Float32frombits(h.byteOrder.Uint32(b)))
+			} else {
+				v = float64(int16(h.byteOrder.Uint16(b)))
+			}
+
+			if (h.hasNoData && v == h.noData) || math.IsNaN(v) {
+				continue
+			}
+
+			i := iLon*grid.NLat + iLat
+			if cnt[i] < math.MaxUint16 {
+				sum[i] += float32(v)
+				cnt[i]++
+			}
+		}
+	}
+
+	return nil
+}
+
+// Elevation dependency of snow depth. Within each source cell (the model's resolution) depth
+// varies with elevation relative to the cell's mean elevation:
+//
+//	f = clamp(1 + Gradient * (h - h_mean) / 100 m, MinFactor, MaxFactor)
+//
+// and the factors are normalized so the source cell keeps its mean snow depth.
+type SnowLineModel struct {
+	Gradient  float32 // relative change per 100 m
+	MinFactor float32
+	MaxFactor float32
+	SourceRes float32 // resolution of the model in °, 0.25 for GFS
+}
+
+var DefaultSnowLineModel = SnowLineModel{Gradient: 0.15, MinFactor: 0, MaxFactor: 3, SourceRes: 0.25}
+
+func (sm SnowLineModel) String() string {
+	return fmt.Sprintf("gradient: %0.3f/100 m, factor: [%0.2f, %0.2f], source res: %0.3f°",
+		sm.Gradient, sm.MinFactor, sm.MaxFactor, sm.SourceRes)
+}
+
+func SnowLineModelFromEnv(logger logger.Logger) SnowLineModel {
+	sm := DefaultSnowLineModel
+	sm.Gradient = envFloat(logger, "SNOW_DEM_GRADIENT", sm.Gradient)
+	sm.MinFactor = envFloat(logger, "SNOW_DEM_MIN_FACTOR", sm.MinFactor)
+	sm.MaxFactor = envFloat(logger, "SNOW_DEM_MAX_FACTOR", sm.MaxFactor)
+	sm.SourceRes = envFloat(logger, "SNOW_DEM_SOURCE_RES", sm.SourceRes)
+	return sm
+}
+
+// redistribute snow within the model's cells according to elevation
+func DownscaleSnow(snow *depthMap, elev *ElevationMap, sm SnowLineModel) (*depthMap, error) {
+	g := &snow.grid
+	di, dj, ok := g.offsetIn(&elev.grid)
+	if !ok {
+		return nil, fmt.Errorf("snow grid %s does not match elevation grid %s", g.String(), elev.grid.String())
+	}
+	if sm.SourceRes <= 0 {
+		return nil, fmt.Errorf("invalid source resolution %f", sm.SourceRes)
+	}
+
+	elev_at := func(i, j int) (float32, bool) {
+		return elev.GetIdx(i+di, j+dj)
+	}
+
+	// source cells are centered on multiples of SourceRes, on regional grids only those covering the grid
+	src := NewGlobalGrid(sm.SourceRes, sm.SourceRes)
+	if !g.WrapLon {
+		res := float64(sm.SourceRes)
+		lon0, lat0 := g.LonLat(0, 0)
+		lon1, lat1 := g.LonLat(g.NLon-1, g.NLat-1)
+		w := float32(math.Round(float64(lon0)/res) * res)
+		s := float32(math.Max(-90, math.Round(float64(lat0)/res)*res))
+		src = NewGrid(w, s, sm.SourceRes, sm.SourceRes,
+			int(math.Round(float64(lon1-w)/res))+1, int(math.Round(float64(min(90, lat1)-s)/res))+1)
+	}
+	src_idx := func(i, j int) int {
+		lon, lat := g.LonLat(i, j)
+		si, sj, _ := src.Idx(lon, lat)
+		si, sj = src.clamp(si, sj)
+		return si*src.NLat + sj
+	}
+
+	// mean elevation of each source cell
+	h_sum := make([]float32, src.Size())
+	h_cnt := make([]int32, src.Size())
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			if h, ok := elev_at(i, j); ok {
+				k := src_idx(i, j)
+				h_sum[k] += h
+				h_cnt[k]++
+			}
+		}
+	}
+
+	factor := func(i, j int) (float32, int) {
+		k := src_idx(i, j)
+		h, ok := elev_at(i, j)
+		if !ok || h_cnt[k] == 0 {
+			return 1, k
+		}
+		h_mean := h_sum[k] / float32(h_cnt[k])
+		f := 1 + sm.Gradient*(h-h_mean)/100
+		return min(max(f, sm.MinFactor), sm.MaxFactor), k
+	}
+
+	has_snow := func(i, j int) bool {
+		sd := snow.at(i, j)
+		return sd > 0 && sd != g.NoData
+	}
+
+	// mean factor of the cells with snow in each source cell, so the snow
+	// that is moved around sums up to the source value
+	f_sum := make([]float32, src.Size())
+	f_cnt := make([]int32, src.Size())
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			if !has_snow(i, j) {
+				continue
+			}
+			f, k := factor(i, j)
+			f_sum[k] += f
+			f_cnt[k]++
+		}
+	}
+
+	new_dm := newDepthMapStorage(snow.Logger, snow.name+" + DEM", snow.grid, snow.storage)
+	new_dm.interp = snow.interp
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			sd := snow.at(i, j)
+			if !has_snow(i, j) {
+				new_dm.set(i, j, sd)
+				continue
+			}
+
+			f, k := factor(i, j)
+			f_mean := f_sum[k] / float32(f_cnt[k])
+			if f_mean > 0 {
+				sd *= f / f_mean
+			}
+			new_dm.set(i, j, sd)
+		}
+	}
+
+	return new_dm, nil
+}
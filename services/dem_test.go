@@ -0,0 +1,97 @@
+package services
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 10x10 pixels of 0.05° in BIL format, elevation rises from west to east
+func writeTestDem(t *testing.T, dir string) string {
+	hdr := filepath.Join(dir, "test.hdr")
+	err := os.WriteFile(hdr, []byte("BYTEORDER M\nLAYOUT BIL\nNROWS 10\nNCOLS 10\nNBANDS 1\nNBITS 16\n"+
+		"ULXMAP 0.0\nULYMAP 0.45\nXDIM 0.05\nYDIM 0.05\nNODATA -9999\n"), 0644)
+	assert.NoError(t, err)
+
+	data := make([]byte, 0, 200)
+	for r := 0; r < 10; r++ {
+		for c := 0; c < 10; c++ {
+			v := int16(100 * c)
+			if r == 0 && c == 0 {
+				v = -9999
+			}
+			data = binary.BigEndian.AppendUint16(data, uint16(v))
+		}
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "test.bil"), data, 0644))
+	return hdr
+}
+
+func TestDownscaleSnow(t *testing.T) {
+	dir := t.TempDir()
+	writeTestDem(t, dir)
+
+	grid := NewGrid(0, 0, 0.1, 0.1, 5, 5)
+	elev, err := LoadElevationMap(newMockLogger(), dir, grid)
+	assert.NoError(t, err)
+
+	h0, ok := elev.GetIdx(0, 2)
+	assert.True(t, ok)
+	h4, ok := elev.GetIdx(4, 2)
+	assert.True(t, ok)
+	assert.Less(t, h0, h4)
+
+	snow := newDepthMap(newMockLogger(), "uniform", grid)
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 5; j++ {
+			snow.set(i, j, 0.1)
+		}
+	}
+
+	sm := SnowLineModel{Gradient: 0.2, MinFactor: 0, MaxFactor: 3, SourceRes: 1}
+	ds, err := DownscaleSnow(snow, elev, sm)
+	assert.NoError(t, err)
+
+	sum := float32(0)
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 5; j++ {
+			sum += ds.GetIdx(i, j)
+		}
+		if i > 0 {
+			assert.Greater(t, ds.GetIdx(i, 2), ds.GetIdx(i-1, 2))
+		}
+	}
+
+	// the source cell keeps its snow
+	assert.InDelta(t, 25*0.1, sum, 1e-4)
+
+	// the snow of a partly covered source cell stays on the covered cells
+	for j := 0; j < 5; j++ {
+		snow.set(0, j, 0)
+	}
+	ds, err = DownscaleSnow(snow, elev, sm)
+	assert.NoError(t, err)
+	sum = 0
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 5; j++ {
+			sum += ds.GetIdx(i, j)
+		}
+	}
+	assert.Equal(t, float32(0), ds.GetIdx(0, 2))
+	assert.InDelta(t, 20*0.1, sum, 1e-4)
+
+	_, err = DownscaleSnow(snow, elev, SnowLineModel{})
+	assert.Error(t, err)
+
+	_, err = LoadElevationMap(newMockLogger(), t.TempDir(), grid)
+	assert.Error(t, err)
+
+	// without ULXMAP the corner would silently be at 0°
+	hdr := filepath.Join(t.TempDir(), "test.hdr")
+	os.WriteFile(hdr, []byte("BYTEORDER M\nLAYOUT BIL\nNROWS 10\nNCOLS 10\nNBANDS 1\nNBITS 16\n"+
+		"ULYMAP 0.45\nXDIM 0.05\nYDIM 0.05\n"), 0644)
+	_, err = readBilHeader(hdr)
+	assert.ErrorContains(t, err, "incomplete header")
+}
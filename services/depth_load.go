@@ -0,0 +1,78 @@
+package services
+
+import (
+	"fmt"
+	"os"
+)
+
+// limits for a load to be trusted
+const (
+	maxRejectedFraction = 0.01     // of the data rows
+	maxInvalidFraction  = 0.01     // NaN or negative values
+	maxPlausibleDepth   = 50       // m
+	minCoverage         = 0.9      // of a global grid, wgrib2 writes all grid points
+	gribUndefined       = 9.999e20 // wgrib2's value for undefined points (e.g. over sea)
+)
+
+// what LoadCsv found in a file
+type LoadReport struct {
+	File     string
+	Rows     int // data rows, without the header
+	Rejected int // unparsable rows or coordinates out of range
+	OffGrid  int // valid rows outside of the map's grid
+	Cells    int // distinct grid points set
+	GridSize int // grid points of a global map, 0 for a regional map
+	Min, Max float32
+	Undef    int // undefined in the grib file, stored as 0
+	NaN      int // stored as 0
+	Negative int // stored as 0
+}
+
+func (r *LoadReport) String() string {
+	return fmt.Sprintf("'%s': rows: %d, rejected: %d, off grid: %d, cells: %d, range: [%0.3f, %0.3f] m, undefined: %d, NaN: %d, negative: %d",
+		r.File, r.Rows, r.Rejected, r.OffGrid, r.Cells, r.Min, r.Max, r.Undef, r.NaN, r.Negative)
+}
+
+// error if the data looks too broken to be used
+func (r *LoadReport) Validate() error {
+	switch {
+	case r.Rows == 0:
+		return fmt.Errorf("'%s': no data", r.File)
+	case r.Cells == 0:
+		return fmt.Errorf("'%s': no data on the grid", r.File)
+	case float32(r.Cells) < minCoverage*float32(r.GridSize):
+		return fmt.Errorf("'%s': only %d of %d grid points, truncated file?", r.File, r.Cells, r.GridSize)
+	case float32(r.Rejected) > maxRejectedFraction*float32(r.Rows):
+		return fmt.Errorf("'%s': %d of %d rows rejected", r.File, r.Rejected, r.Rows)
+	case float32(r.NaN+r.Negative) > maxInvalidFraction*float32(r.Rows):
+		return fmt.Errorf("'%s': %d NaN and %d negative values in %d rows", r.File, r.NaN, r.Negative, r.Rows)
+	case r.Max > maxPlausibleDepth:
+		return fmt.Errorf("'%s': implausible snow depth %0.1f m", r.File, r.Max)
+	}
+	return nil
+}
+
+// load a csv file as written by wgrib2 -csv or in the "longitude, latitude, value" format into the map
+// the error is only set if the file could not be read, the data is checked with LoadReport.Validate
+// each grid point should appear only once, for duplicates it's undefined which value is kept
+func (m *depthMap) LoadCsv(csv_name string) (*LoadReport, error) {
+	file, err := os.Open(csv_name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	p := newCsvLoader(m)
+	if err := p.run(file); err != nil {
+		return nil, fmt.Errorf("'%s': %w", csv_name, err)
+	}
+
+	r := p.report()
+	r.File = csv_name
+	if m.grid.WrapLon {
+		r.GridSize = m.grid.Size()
+	}
+	m.Logger.Infof("%s depth map: %s", m.name, r.String())
+	m.Logger.Infof("%s storage: %s, %d kB", m.name, m.storage.String(), m.val.memSize()/1024)
+	return r, nil
+}
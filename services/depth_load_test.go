@@ -0,0 +1,218 @@
+package services
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writeCsv(t *testing.T, content string) string {
+	name := filepath.Join(t.TempDir(), "snod.csv")
+	assert.NoError(t, os.WriteFile(name, []byte(content), 0644))
+	return name
+}
+
+func TestLoadCsvReport(t *testing.T) {
+	m := newDepthMap(newMockLogger(), "Snow", GlobalGrid)
+	r, err := m.LoadCsv("../testdata/EDVK_snod.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, GlobalGrid.Size(), r.GridSize)
+	assert.ErrorContains(t, r.Validate(), "truncated") // 4 points of a global grid
+	assert.Equal(t, 4, r.Rows)
+	assert.Equal(t, 4, r.Cells)
+	assert.Equal(t, 0, r.Rejected)
+	assert.InDelta(t, 0.1, r.Min, 1e-6)
+	assert.InDelta(t, 0.5, r.Max, 1e-6)
+
+	// missing file
+	_, err = m.LoadCsv("../testdata/no_such_file.csv")
+	assert.Error(t, err)
+
+	// broken rows, out of range coordinates, undefined, NaN and negative values
+	name := writeCsv(t, `longitude, latitude, value,
+9.3, 51.4, 0.5,
+9.4, 51.4, 0.4,
+9.3, 51.4
+9.3, abc, 0.4,
+360.5, 51.4, 0.4,
+9.3, 91, 0.4,
+9.5, 51.4, 9.999e+20,
+9.6, 51.4, NaN,
+9.7, 51.4, -0.1,
+9.3, 51.4, 0.6,
+`)
+	m = newDepthMap(newMockLogger(), "Snow", GlobalGrid)
+	r, err = m.LoadCsv(name)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, r.Rows)
+	assert.Equal(t, 4, r.Rejected)
+	assert.Equal(t, 5, r.Cells)
+	assert.Equal(t, 1, r.Undef)
+	assert.Equal(t, 1, r.NaN)
+	assert.Equal(t, 1, r.Negative)
+	assert.InDelta(t, 0.6, r.Max, 1e-6)
+	assert.Equal(t, float32(0), m.Get(9.6, 51.4))
+	assert.Equal(t, float32(0), m.Get(9.7, 51.4))
+	assert.Error(t, r.Validate())
+
+	// off grid rows are fine
+	g := NewGrid(9.3, 51.4, 0.1, 0.1, 2, 2)
+	m = newDepthMap(newMockLogger(), "EDVK", g)
+	r, err = m.LoadCsv(writeCsv(t, "9.3, 51.4, 0.5\n20, 51.4, 0.5\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.Rows)
+	assert.Equal(t, 1, r.OffGrid)
+	assert.NoError(t, r.Validate())
+
+	// a global grid needs (almost) all points
+	g = NewGlobalGrid(1, 1)
+	var b strings.Builder
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			fmt.Fprintf(&b, "%d, %d, 0.1\n", i, j-90)
+		}
+	}
+	m = newDepthMap(newMockLogger(), "Coarse", g)
+	r, err = m.LoadCsv(writeCsv(t, b.String()))
+	assert.NoError(t, err)
+	assert.Equal(t, g.Size(), r.Cells)
+	assert.NoError(t, r.Validate())
+	r, err = m.LoadCsv(writeCsv(t, b.String()[:b.Len()/2]))
+	assert.NoError(t, err)
+	assert.Error(t, r.Validate())
+
+	// empty and implausible
+	m = newDepthMap(newMockLogger(), "EDVK", NewGrid(9.3, 51.4, 0.1, 0.1, 2, 2))
+	r, err = m.LoadCsv(writeCsv(t, "longitude, latitude, value\n"))
+	assert.NoError(t, err)
+	assert.Error(t, r.Validate())
+	r, err = m.LoadCsv(writeCsv(t, "9.3, 51.4, 500\n"))
+	assert.NoError(t, err)
+	assert.Error(t, r.Validate())
+}
+
+func TestParseFloatBytes(t *testing.T) {
+	for _, s := range []string{"0", "-0.5", "+1.25", "0.000123", "9.999e+20", "9.999E20", "1e-5", "123456.789",
+		"-179.95", "89.9", "0.1", "12345678901234567890123", "1.00000000000000000000001", ".5", "5."} {
+		want, err := strconv.ParseFloat(s, 64)
+		assert.NoError(t, err, s)
+		v, ok := parseFloatBytes([]byte(s))
+		assert.True(t, ok, s)
+		assert.InEpsilon(t, want+1e-300, v+1e-300, 1e-14, s)
+	}
+
+	v, ok := parseFloatBytes([]byte(" NaN "))
+	assert.True(t, ok)
+	assert.True(t, math.IsNaN(v))
+
+	for _, s := range []string{"", "-", "abc", "1.2.3", "1e", "1x", "--1"} {
+		_, ok := parseFloatBytes([]byte(s))
+		assert.False(t, ok, s)
+	}
+
+	assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() { parseFloatBytes([]byte("-123.456e-2")) }))
+}
+
+// global 0.1° csv in wgrib2 format covering lat rows [-90, -90 + n_lat * 0.1)
+func writeGlobalCsv(tb testing.TB, n_lat int) string {
+	name := filepath.Join(tb.TempDir(), "snod.csv")
+	f, err := os.Create(name)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	w.WriteString("longitude, latitude, value,\n")
+	for j := 0; j < n_lat; j++ {
+		for i := 0; i < 3600; i++ {
+			fmt.Fprintf(w, "%0.6f,%0.6f,%g\n", float32(i)*0.1, -90+float32(j)*0.1, float32((i+j)%100)*0.01)
+		}
+	}
+	w.Flush()
+	f.Close()
+	return name
+}
+
+func TestLoadCsvChunks(t *testing.T) {
+	// several chunks
+	name := writeGlobalCsv(t, 30)
+	st, _ := os.Stat(name)
+	assert.Greater(t, st.Size(), int64(2*csvChunkSize))
+
+	for _, storage := range []Storage{StorageFloat, StorageQuantized, StorageSparse} {
+		m := newDepthMapStorage(newMockLogger(), "Snow", GlobalGrid, storage)
+		r, err := m.LoadCsv(name)
+		assert.NoError(t, err)
+		assert.Error(t, r.Validate()) // 30 of 1801 latitude rows
+		assert.Equal(t, 30*3600, r.Rows)
+		assert.Equal(t, 30*3600, r.Cells)
+		assert.Equal(t, 0, r.Rejected)
+		assert.InDelta(t, 0.99, r.Max, 1e-6)
+
+		for _, ij := range [][2]int{{0, 0}, {1234, 7}, {3599, 29}} {
+			assert.InDelta(t, float32((ij[0]+ij[1])%100)*0.01, m.GetIdx(ij[0], ij[1]), 1e-3)
+		}
+	}
+
+	// no trailing newline, CRLF, a line longer than a chunk
+	long := strings.Repeat(" ", 3*csvChunkSize)
+	m := newDepthMap(newMockLogger(), "Snow", GlobalGrid)
+	r, err := m.LoadCsv(writeCsv(t, "longitude, latitude, value\r\n9.3, 51.4, 0.5\r\n9.4,"+long+"51.4, 0.4\r\n9.5, 51.4, 0.3"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, r.Rows)
+	assert.Equal(t, 0, r.Rejected)
+	assert.InDelta(t, 0.3, m.GetIdx(95, 1414), 1e-6)
+}
+
+// reference: the serial encoding/csv loader this replaced
+func loadCsvSerial(m *depthMap, csv_name string) error {
+	file, err := os.Open(csv_name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	first := true
+	for {
+		record, err := reader.Read()
+		if err != nil {
+			break
+		}
+		if first {
+			first = false
+			continue
+		}
+		lon, _ := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
+		lat, _ := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		v, _ := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
+		if x, y, ok := m.grid.Idx(float32(lon), float32(lat)); ok {
+			m.set(x, y, float32(v))
+		}
+	}
+	return nil
+}
+
+// go test ./services -run '^$' -bench LoadCsv
+func BenchmarkLoadCsv(b *testing.B) {
+	name := writeGlobalCsv(b, 300) // 1.08M lines
+	m := newDepthMap(newMockLogger(), "Snow", GlobalGrid)
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			loadCsvSerial(m, name)
+		}
+	})
+	b.Run("chunked", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m.LoadCsv(name)
+		}
+	})
+}
@@ -1,11 +1,11 @@
 package services
 
 import (
-	"encoding/csv"
+	"github.com/xairline/xa-snow/utils/coast"
 	"github.com/xairline/xa-snow/utils/logger"
-	"os"
-	"strconv"
-	"strings"
+	"math"
+	"sort"
+	"sync"
 )
 
 // depth map of the world in 0.1° resolution
@@ -14,192 +14,280 @@ const n_iLat = 1801
 
 type DepthMap interface {
 	Get(lon, lat float32) float32
-	LoadCsv(csv_name string)
+	LoadCsv(csv_name string) (*LoadReport, error) // see depth_load.go
 
 	// get by index with wrap around
 	GetIdx(iLon, iLat int) float32
+
+	Grid() *Grid
+	Name() string
 }
 
 type depthMap struct {
-	Logger logger.Logger
-	name   string
-	val    [n_iLon][n_iLat]float32
+	Logger  logger.Logger
+	name    string
+	grid    Grid
+	storage Storage
+	interp  Interpolation
+	val     depthStore
 }
 
-// load csv file into depth map
-func (m *depthMap) LoadCsv(csv_name string) {
-	// read csv file into 2D array
-	file, err := os.Open(csv_name)
-	if err != nil {
-		m.Logger.Errorf("Error opening file: %v", err)
-	}
-	defer file.Close()
+func newDepthMap(logger logger.Logger, name string, grid Grid) *depthMap {
+	return newDepthMapStorage(logger, name, grid, StorageFloat)
+}
 
-	// Create a new CSV reader
-	reader := csv.NewReader(file)
-	counter := 0
+func newDepthMapStorage(logger logger.Logger, name string, grid Grid, storage Storage) *depthMap {
+	m := &depthMap{Logger: logger, name: name, grid: grid, storage: storage}
+	m.val = newDepthStore(storage, &m.grid, grid.NoData)
+	return m
+}
 
-	for {
-		record, err := reader.Read()
-		if err != nil {
-			break
-		}
-		if counter == 0 {
-			counter++
-			continue
-		}
+// deep copy under another name
+func (m *depthMap) clone(name string) *depthMap {
+	return &depthMap{Logger: m.Logger, name: name, grid: m.grid, storage: m.storage, interp: m.interp, val: m.val.clone()}
+}
 
-		// Parse longitude, latitude, and value
-		lon, _ := strconv.ParseFloat(strings.TrimSpace(record[0]), 64)
-		lat, _ := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
-		var value float32
-		if strings.Contains(record[2], "e") {
-			value = 0
-		}
-		tmpVal, _ := strconv.ParseFloat(strings.TrimSpace(record[2]), 64)
-		value = float32(tmpVal)
+func NewDepthMap(logger logger.Logger, name string, grid Grid) DepthMap {
+	return newDepthMap(logger, name, grid)
+}
 
-		// Convert longitude and latitude to array indices
-		// This example assumes the CSV contains all longitudes and latitudes
-		x := int(lon * 10)        // Adjust these calculations based on your data's range and resolution
-		y := int((lat + 90) * 10) // Adjust for negative latitudes
+func NewDepthMapStorage(logger logger.Logger, name string, grid Grid, storage Storage) DepthMap {
+	return newDepthMapStorage(logger, name, grid, storage)
+}
 
-		// Store the value
-		m.val[x][y] = value
-		counter++
-	}
-	m.Logger.Infof("%s depth map size: %d", m.name, counter)
-	m.Logger.Infof("Loading CSV file '%s': Done", csv_name)
+func (m *depthMap) Grid() *Grid {
+	return &m.grid
 }
 
-func (m *depthMap) GetIdx(iLon, iLat int) float32 {
-	// for lon we wrap around
-	if iLon >= n_iLon {
-		iLon -= n_iLon
-	} else if iLon < 0 {
-		iLon += n_iLon
-	}
+func (m *depthMap) Name() string {
+	return m.name
+}
 
-	// for lat we just confine, doesn't make a difference anyway
-	if iLat >= n_iLat {
-		iLat = n_iLat - 1
-	} else if iLat < 0 {
-		iLat = 0
-	}
+// same values with another kernel, the map itself is not changed
+func (m *depthMap) withInterpolation(ip Interpolation) *depthMap {
+	dm := *m
+	dm.interp = ip
+	return &dm
+}
 
-	return m.val[iLon][iLat]
+// set by index, no wrap around
+func (m *depthMap) set(iLon, iLat int, v float32) {
+	m.val.set(iLon, iLat, v)
 }
 
-func (m *depthMap) Get(lon, lat float32) float32 {
-	// our snow world map is 3600x1801 [0,359.9]x[0,180.0]
-	lat += 90.0
+// get by index, no wrap around
+func (m *depthMap) at(iLon, iLat int) float32 {
+	return m.val.get(iLon, iLat)
+}
 
-	// longitude is -180 to 180, we need to convert it to 0 to 360
-	if lon < 0 {
-		lon = lon + 360
+func (m *depthMap) GetIdx(iLon, iLat int) float32 {
+	// for lon we wrap around, for lat we just confine, doesn't make a difference anyway
+	iLon, iLat, ok := m.grid.wrap(iLon, iLat)
+	if !ok {
+		return m.grid.NoData
 	}
 
-	lon *= 10
-	lat *= 10
+	return m.at(iLon, iLat)
+}
+
+func (m *depthMap) Get(lon, lat float32) float32 {
+	// longitude is -180 to 180, the grid takes care of converting it to its own range
+	fLon, fLat := m.grid.FIdx(lon, lat)
+	if !m.grid.inside(fLon, fLat) {
+		return m.grid.NoData
+	}
 
 	// index of tile is lower left corner
-	iLon := int(lon)
-	iLat := int(lat)
+	iLon := int(math.Floor(float64(fLon)))
+	iLat := int(math.Floor(float64(fLat)))
 
 	// (s, t) coordinates of (lon, lat) within tile, s,t in [0,1]
-	s := lon - float32(iLon)
-	t := lat - float32(iLat)
+	s := fLon - float32(iLon)
+	t := fLat - float32(iLat)
 
-	//m.Logger.Infof("(%f, %f) -> (%d, %d) (%f, %f)", lon/10, lat/10 - 90, iLon, iLat, s, t)
-	v00 := m.GetIdx(iLon, iLat)
-	v10 := m.GetIdx(iLon+1, iLat)
-	v01 := m.GetIdx(iLon, iLat+1)
-	v11 := m.GetIdx(iLon+1, iLat+1)
+	//m.Logger.Infof("(%f, %f) -> (%d, %d) (%f, %f)", lon, lat, iLon, iLat, s, t)
+	return m.interpolate(iLon, iLat, s, t)
+}
 
-	// Lagrange polynoms: pij = is 1 on corner ij and 0 elsewhere
-	p00 := (1 - s) * (1 - t)
-	p10 := s * (1 - t)
-	p01 := (1 - s) * t
-	p11 := s * t
+// get by index, confined to the grid
+func (m *depthMap) getClamped(iLon, iLat int) float32 {
+	iLon, iLat = m.grid.clamp(iLon, iLat)
+	return m.at(iLon, iLat)
+}
 
-	v := v00*p00 + v10*p10 + v01*p01 + v11*p11
-	//m.Logger.Infof("vij: %f, %f, %f, %f; v: %f", v00, v10, v01, v11, v)
-	return v
+// a coast map and how to extend snow to its coast line
+type CoastLayer struct {
+	Cs     CoastService
+	Params CoastParams
 }
 
-func ElsaOnTheCoast(gribSnow *depthMap, cs CoastService) DepthMap {
-	new_dm := &depthMap{name: "Snow + Coast", Logger: gribSnow.Logger}
+func ElsaOnTheCoast(gribSnow *depthMap, cs CoastService, p CoastParams) DepthMap {
+	return ElsaOnTheShores(gribSnow, []CoastLayer{{Cs: cs, Params: p}})
+}
 
-	const min_sd = float32(0.02) // only go higher than this snow depth
+// ElsaOnTheCoast for several coast maps, e.g. the oceans and inland water, each with its own
+// parameters. A point that is coast in more than one map is handled by the first one.
+func ElsaOnTheShores(gribSnow *depthMap, layers []CoastLayer) DepthMap {
+	// the coast map is defined on the global 0.1° grid, regional grids must be part of it
+	g := &gribSnow.grid
+	di, dj, ok := g.offsetIn(&GlobalGrid)
+	if !ok {
+		gribSnow.Logger.Errorf("ElsaOnTheCoast: unsupported grid %s", g.String())
+		return gribSnow
+	}
 
-	n_extend := 0
+	new_dm := gribSnow.clone("Snow + Coast")
 
-	for i := 0; i < n_iLon; i++ {
-		for j := 0; j < n_iLat; j++ {
-			sd := gribSnow.GetIdx(i, j)
-			sdn := new_dm.val[i][j] // may already be set by inland extension earlier
-			if sd > sdn {           // always maximize
-				new_dm.val[i][j] = sd
-			}
+	reach := 1 // how far writes go from a coast point
+	fast := make([]CoastLayer, len(layers))
+	for l := range layers {
+		fast[l] = CoastLayer{Cs: fastCoast(layers[l].Cs), Params: layers[l].Params}
+		reach = max(reach, layers[l].Params.MaxStep)
+	}
 
-			const max_step = 3 // to look for inland snow ~ 5 to 10 km / step
-			if is_coast, dir_x, dir_y, _ := cs.IsCoast(i, j); is_coast && sd <= min_sd {
-				// look for inland snow
-				inland_dist := 0
-				inland_sd := float32(0)
-				for k := 1; k <= max_step; k++ {
-					ii := i + k*dir_x
-					jj := j + k*dir_y
-
-					if k < max_step && cs.IsWater(ii, jj) { // if possible skip water
-						continue
-					}
-
-					tmp := gribSnow.GetIdx(ii, jj)
-					if tmp > sd && tmp > min_sd { // found snow
-						inland_dist = k
-						inland_sd = tmp
-						break
-					}
-				}
+	bands := lonBands(g.NLon, reach)
+	n_bands := len(bands) - 1
 
-				const decay = float32(0.8) // snow depth decay per step
-				if inland_dist > 0 {
-					//g.Logger.Infof("Inland snow detected for (%d, %d) at dist %d, sd: %0.3f %0.3f",
-					//				 i, j, inland_dist, sd, inland_sd)
-
-					// use power law from inland point to coast line point
-					for k := inland_dist - 1; k >= 0; k-- {
-						inland_sd *= decay
-						if inland_sd < min_sd {
-							inland_sd = min_sd
-						}
-						x := i + k*dir_x
-						y := j + k*dir_y
-						if x >= n_iLon {
-							x -= n_iLon
-						}
-						if x < 0 {
-							x += n_iLon
-						}
-
-                        // the poles are tricky so we just clamp
-                        // anyway it does not make a difference
-						if y >= n_iLat {
-							y = n_iLat
-						}
-						if y < 0 {
-							y = 0
-						}
-						new_dm.val[x][y] = inland_sd
-						n_extend++
-					}
-				}
+	// coast points by band and layer in grid indices, a point that is coast in an earlier layer
+	// belongs to that one
+	points := make([][][]coast.Point, n_bands)
+	for b := range points {
+		points[b] = make([][]coast.Point, len(fast))
+	}
+	for l := range fast {
+		for _, p := range fast[l].Cs.CoastPoints() {
+			i := (int(p.I) - di) % n_iLon
+			if i < 0 {
+				i += n_iLon
 			}
+			j := int(p.J) - dj
+			if i >= g.NLon || j < 0 || j >= g.NLat || isCoastIn(fast[:l], int(p.I), int(p.J)) {
+				continue
+			}
+			b := sort.SearchInts(bands, i+1) - 1
+			p.I, p.J = int32(i), int32(j)
+			points[b][l] = append(points[b][l], p)
 		}
 	}
 
-	new_dm.Logger.Infof("Extended costal snow on %d grid points", n_extend)
+	// Extensions maximize so their order doesn't matter. They cross into the neighbouring bands,
+	// so even and odd bands take turns.
+	n_extend := make([][]int, n_bands) // [band][layer]
+	for phase := 0; phase < 2; phase++ {
+		runBands(n_bands, phase, 2, func(b int) {
+			n_extend[b] = make([]int, len(fast))
+			for l := range fast {
+				for _, p := range points[b][l] {
+					n_extend[b][l] += elsaExtend(gribSnow, new_dm, &fast[l], p, di, dj)
+				}
+			}
+		})
+	}
+
+	for l := range layers {
+		n_points, n := 0, 0
+		for b := range n_extend {
+			n_points += len(points[b][l])
+			n += n_extend[b][l]
+		}
+		new_dm.Logger.Infof("Extended costal snow on %d grid points from %d coast points (%s)",
+			n, n_points, layers[l].Params.Name)
+	}
 	return new_dm
 }
 
+func isCoastIn(layers []CoastLayer, ci, cj int) bool {
+	for l := range layers {
+		if yes, _, _, _ := layers[l].Cs.IsCoast(ci, cj); yes {
+			return true
+		}
+	}
+	return false
+}
+
+// Extend inland snow to coast point p in grid indices, the coast service wraps and clamps the
+// global indices (i + di, j + dj) itself. -> # of points written
+func elsaExtend(gribSnow, new_dm *depthMap, layer *CoastLayer, p coast.Point, di, dj int) int {
+	g := &gribSnow.grid
+	cs, pa := layer.Cs, &layer.Params
+	i, j := int(p.I), int(p.J)
+	ci, cj := i+di, j+dj
+	dir_x, dir_y := int(p.DirX), int(p.DirY)
+
+	sd := gribSnow.at(i, j)
+	min_sd := pa.MinSd // only go higher than this snow depth
+	if sd > min_sd {
+		return 0
+	}
+
+	// look for inland snow ~ 5 to 10 km / step
+	inland_dist := 0
+	inland_sd := float32(0)
+	for k := 1; k <= pa.MaxStep; k++ {
+		if k < pa.MaxStep && cs.IsWater(ci+k*dir_x, cj+k*dir_y) { // if possible skip water
+			continue
+		}
+
+		tmp := gribSnow.GetIdx(i+k*dir_x, j+k*dir_y)
+		if tmp > sd && tmp > min_sd { // found snow
+			inland_dist = k
+			inland_sd = tmp
+			break
+		}
+	}
+
+	// use power law from inland point to coast line point
+	n := 0
+	for k := inland_dist - 1; k >= 0; k-- {
+		inland_sd *= pa.Decay // snow depth decay per step
+		if inland_sd < min_sd {
+			inland_sd = min_sd
+		}
+
+		// lon wraps, the poles are tricky so we just clamp,
+		// anyway it does not make a difference
+		x, y, ok := g.wrap(i+k*dir_x, j+k*dir_y)
+		if !ok {
+			continue // beyond a regional grid
+		}
+		if inland_sd > new_dm.at(x, y) { // always maximize
+			new_dm.set(x, y, inland_sd)
+		}
+		n++
+	}
+	return n
+}
+
+// Boundaries of longitude bands for parallel processing. A band is a multiple of the sparse
+// store's blocks and so wide that writes up to reach points beyond two bands of the same parity
+// never meet, not even in the same block. The number of bands is even, so this holds across the
+// wrap of a global grid as well. Small grids get just one band.
+func lonBands(n_lon, reach int) []int {
+	n_blk := n_lon >> sparseBlockShift
+	min_blk := (2*(reach+sparseBlockDim) + sparseBlockMask) >> sparseBlockShift
+	n := n_blk / min_blk
+	n -= n % 2
+	if n < 2 {
+		return []int{0, n_lon}
+	}
+
+	bands := make([]int, n+1)
+	for b := 1; b < n; b++ {
+		bands[b] = (b * n_blk / n) << sparseBlockShift
+	}
+	bands[n] = n_lon
+	return bands
+}
+
+// f(b) for b = first, first + step, ... < n in parallel
+func runBands(n, first, step int, f func(b int)) {
+	var wg sync.WaitGroup
+	for b := first; b < n; b += step {
+		wg.Add(1)
+		go func(b int) {
+			defer wg.Done()
+			f(b)
+		}(b)
+	}
+	wg.Wait()
+}
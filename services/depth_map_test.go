@@ -0,0 +1,128 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+func newMockLogger() *MockLogger {
+	l := new(MockLogger)
+	l.On("Infof", mock.Anything, mock.Anything).Return()
+	l.On("Errorf", mock.Anything, mock.Anything).Return()
+	return l
+}
+
+func TestGridIdx(t *testing.T) {
+	g := GlobalGrid
+	assert.NoError(t, g.Check())
+
+	i, j, ok := g.Idx(9.3, 51.4)
+	assert.True(t, ok)
+	assert.Equal(t, 93, i)
+	assert.Equal(t, 1414, j)
+
+	i, j, ok = g.Idx(-0.1, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 3599, i)
+	assert.Equal(t, 900, j)
+
+	// rounds up to 360° which is index 0 again
+	i, _, ok = g.Idx(-0.01, 0)
+	assert.True(t, ok)
+	assert.Equal(t, 0, i)
+
+	r := NewGrid(-10, 40, 0.5, 0.5, 41, 21)
+	assert.NoError(t, r.Check())
+	i, j, ok = r.Idx(-5, 45)
+	assert.True(t, ok)
+	assert.Equal(t, 10, i)
+	assert.Equal(t, 10, j)
+	_, _, ok = r.Idx(11, 45)
+	assert.False(t, ok)
+
+	bad := Grid{DLon: 0.1, DLat: 0.1, NLon: 100, NLat: 10, WrapLon: true}
+	assert.Error(t, bad.Check())
+}
+
+func TestRegionalLoadCsv(t *testing.T) {
+	g := NewGrid(9.3, 51.4, 0.1, 0.1, 2, 2)
+	g.NoData = -1
+	m := NewDepthMap(newMockLogger(), "EDVK", g)
+	_, err := m.LoadCsv("../testdata/EDVK_snod.csv")
+	assert.NoError(t, err)
+
+	assert.InDelta(t, 0.5, m.GetIdx(0, 0), 1e-6)
+	assert.InDelta(t, 0.4, m.GetIdx(1, 0), 1e-6)
+	assert.InDelta(t, 0.2, m.GetIdx(0, 1), 1e-6)
+	assert.InDelta(t, 0.1, m.GetIdx(1, 1), 1e-6)
+
+	// center is the mean of the corners
+	assert.InDelta(t, 0.3, m.Get(9.35, 51.45), 1e-5)
+	assert.InDelta(t, 0.45, m.Get(9.35, 51.4), 1e-5)
+
+	// outside
+	assert.Equal(t, float32(-1), m.Get(9.2, 51.45))
+	assert.Equal(t, float32(-1), m.Get(9.35, 51.6))
+	assert.Equal(t, float32(-1), m.GetIdx(2, 0))
+}
+
+func TestCoarseGlobalWrap(t *testing.T) {
+	g := NewGlobalGrid(10, 10)
+	assert.NoError(t, g.Check())
+	assert.Equal(t, 36, g.NLon)
+	assert.Equal(t, 19, g.NLat)
+
+	m := newDepthMap(newMockLogger(), "coarse", g)
+	m.set(35, 9, 1.0) // 350°, equator
+
+	assert.Equal(t, float32(1.0), m.GetIdx(-1, 9))
+	assert.Equal(t, float32(1.0), m.GetIdx(71, 9))
+	assert.InDelta(t, 0.5, m.Get(-5, 0), 1e-6)
+	assert.InDelta(t, 0.5, m.Get(355, 0), 1e-6)
+	assert.InDelta(t, 0.25, m.Get(-5, 5), 1e-6)
+	assert.Equal(t, float32(0), m.Get(5, 0))
+
+	// poles are clamped
+	m.set(0, 18, 2.0)
+	assert.Equal(t, float32(2.0), m.GetIdx(0, 19))
+	assert.InDelta(t, 2.0, m.Get(0, 90), 1e-6)
+}
+
+func TestStorage(t *testing.T) {
+	for _, storage := range []Storage{StorageFloat, StorageQuantized, StorageSparse} {
+		g := NewGrid(0, 0, 0.1, 0.1, 100, 50)
+		g.NoData = -1
+		m := newDepthMapStorage(newMockLogger(), storage.String(), g, storage)
+
+		assert.Equal(t, float32(-1), m.GetIdx(10, 10), storage.String())
+		m.set(10, 10, 0.123)
+		m.set(99, 49, 0)
+		m.set(40, 40, 70) // beyond quantization range
+		assert.InDelta(t, 0.123, m.GetIdx(10, 10), 0.0005, storage.String())
+		assert.Equal(t, float32(0), m.GetIdx(99, 49), storage.String())
+		assert.Equal(t, float32(-1), m.GetIdx(11, 10), storage.String())
+		assert.InDelta(t, 65.5, m.GetIdx(40, 40), 5, storage.String())
+
+		// a clone is independent
+		c := m.clone("copy")
+		c.set(10, 10, 0.5)
+		c.set(20, 20, 0.5)
+		assert.InDelta(t, 0.123, m.GetIdx(10, 10), 0.0005, storage.String())
+		assert.Equal(t, float32(-1), m.GetIdx(20, 20), storage.String())
+		assert.Equal(t, m.GetIdx(40, 40), c.GetIdx(40, 40), storage.String())
+		assert.Equal(t, float32(-1), c.GetIdx(11, 10), storage.String())
+	}
+
+	g := GlobalGrid
+	sparse := newDepthMapStorage(newMockLogger(), "sparse", g, StorageSparse)
+	sparse.set(100, 1500, 0.5)
+	sparse.set(101, 1500, 0.5)
+	assert.Less(t, sparse.val.memSize(), 100*1024)
+
+	s, err := ParseStorage("Quantized")
+	assert.NoError(t, err)
+	assert.Equal(t, StorageQuantized, s)
+	_, err = ParseStorage("zip")
+	assert.Error(t, err)
+}
@@ -0,0 +1,506 @@
+package services
+
+import (
+	"fmt"
+	"github.com/xairline/xa-snow/utils/logger"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Processing of the raw snow map into the final one is a chain of stages configured by
+// SNOW_PIPELINE, e.g. "dem,coast,threshold:0.01". A stage is name[:arg[:arg]].
+//
+//	coast              extend inland snow to the coast line (ElsaOnTheCoast), see SNOW_COAST,
+//	                   and to lake shores, see SNOW_LAKES
+//	dem                redistribute snow with elevation, needs SNOW_DEM
+//	landcover          per land cover class corrections, needs SNOW_LANDCOVER
+//	seaice             snow on frozen sea, needs sea ice data, see SNOW_SEA_ICE
+//	max:<map>          cellwise maximum with a named map
+//	min:<map>          cellwise minimum with a named map
+//	blend:<map>:<w>    (1 - w) * snow + w * map
+//	scale:<f>          multiply by f
+//	threshold:<t>      depths below t are set to 0
+//	mask:land|water    keep snow on land (or water) only
+//	blur:<n>           box blur over (2n + 1) x (2n + 1) grid points
+//	gauss:<s>          mass conserving gaussian smoothing, sigma s in grid points
+//	bilateral:<s>:<r>  same but edge preserving, depth differences >> r m are kept
+//
+// gauss and bilateral keep snow on land (water) if there is a coast map, they are meant to run before coast.
+//
+// Named maps are "raw", the map as loaded, and whatever the caller adds to PipelineContext.Maps.
+const DefaultPipeline = "coast"
+
+type PipelineContext struct {
+	Logger    logger.Logger
+	Cs        CoastService
+	Coast     CoastParams  // zero: DefaultCoastParams
+	Lakes     CoastService // nil if there is no lake mask
+	LakeCoast CoastParams  // zero: the "lakes" set
+	Maps      map[string]DepthMap
+	Elevation *ElevationMap // nil if there is no DEM
+	LandCover *LandCoverMap // nil if there is no land cover raster
+	SnowLine  SnowLineModel
+	SeaIce    *SeaIce // nil if there is no sea ice data
+}
+
+type DepthOp struct {
+	Name  string // as in the spec
+	apply func(pc *PipelineContext, in *depthMap) (*depthMap, error)
+	reach func(pc *PipelineContext, g *Grid) int // nil for stages working point by point
+}
+
+type Pipeline struct {
+	Ops []DepthOp
+}
+
+type opFactory func(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error)
+
+var depthOps map[string]opFactory
+
+// grid points around a point a stage reads, stages that are missing work point by point
+var depthOpReach = map[string]func(args []string, pc *PipelineContext, g *Grid) int{
+	"coast":     coastReach,
+	"dem":       demReach,
+	"blur":      blurReach,
+	"gauss":     smoothReach,
+	"bilateral": smoothReach,
+}
+
+func init() {
+	depthOps = map[string]opFactory{
+		"coast":     opCoast,
+		"dem":       opDem,
+		"landcover": opLandCover,
+		"seaice":    opSeaIce,
+		"max":       opMax,
+		"min":       opMin,
+		"blend":     opBlend,
+		"scale":     opScale,
+		"threshold": opThreshold,
+		"mask":      opMask,
+		"blur":      opBlur,
+		"gauss":     opGauss,
+		"bilateral": opBilateral,
+	}
+}
+
+func ParsePipeline(spec string) (*Pipeline, error) {
+	p := &Pipeline{}
+	for _, stage := range strings.Split(spec, ",") {
+		stage = strings.TrimSpace(stage)
+		if stage == "" {
+			continue
+		}
+		f := strings.Split(stage, ":")
+		name := strings.ToLower(f[0])
+		factory, ok := depthOps[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown stage '%s'", stage)
+		}
+		apply, err := factory(f[1:])
+		if err != nil {
+			return nil, fmt.Errorf("stage '%s': %w", stage, err)
+		}
+		op := DepthOp{Name: stage, apply: apply}
+		if reach, ok := depthOpReach[name]; ok {
+			args := f[1:]
+			op.reach = func(pc *PipelineContext, g *Grid) int { return reach(args, pc, g) }
+		}
+		p.Ops = append(p.Ops, op)
+	}
+	return p, nil
+}
+
+func (p *Pipeline) String() string {
+	names := make([]string, len(p.Ops))
+	for i, op := range p.Ops {
+		names[i] = op.Name
+	}
+	return strings.Join(names, ",")
+}
+
+// grid points around a point the pipeline reads on grid g, the stages add up
+func (p *Pipeline) Reach(pc *PipelineContext, g *Grid) int {
+	n := 0
+	for _, op := range p.Ops {
+		if op.reach != nil {
+			n += op.reach(pc, g)
+		}
+	}
+	return n
+}
+
+// in is not modified
+func (p *Pipeline) Run(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+	dm := in
+	for _, op := range p.Ops {
+		out, err := op.apply(pc, dm)
+		if err != nil {
+			return nil, fmt.Errorf("stage '%s': %w", op.Name, err)
+		}
+		dm = out
+	}
+	return dm, nil
+}
+
+func argCount(args []string, n int) error {
+	if len(args) != n {
+		return fmt.Errorf("expected %d argument(s), got %d", n, len(args))
+	}
+	return nil
+}
+
+func argFloat(s string) (float32, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 32)
+	return float32(v), err
+}
+
+// new map with the same layout as in
+func derivedMap(in *depthMap, name string) *depthMap {
+	dm := newDepthMapStorage(in.Logger, name, in.grid, in.storage)
+	dm.interp = in.interp
+	return dm
+}
+
+// apply f to every grid point
+func mapCells(in *depthMap, name string, f func(i, j int, v float32) float32) *depthMap {
+	dm := derivedMap(in, name)
+	for i := 0; i < in.grid.NLon; i++ {
+		for j := 0; j < in.grid.NLat; j++ {
+			dm.set(i, j, f(i, j, in.at(i, j)))
+		}
+	}
+	return dm
+}
+
+// other on the grid of in, resampled if the grids differ
+func namedMapSampler(pc *PipelineContext, in *depthMap, name string) (func(i, j int) float32, error) {
+	other, ok := pc.Maps[name]
+	if !ok || other == nil {
+		return nil, fmt.Errorf("no map '%s'", name)
+	}
+	if other.Grid().Equal(&in.grid) {
+		return other.GetIdx, nil
+	}
+	return func(i, j int) float32 {
+		lon, lat := in.grid.LonLat(i, j)
+		return other.Get(lon, lat)
+	}, nil
+}
+
+func opCoast(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 0); err != nil {
+		return nil, err
+	}
+	return func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+		layers := coastLayers(pc)
+		if len(layers) == 0 {
+			return nil, fmt.Errorf("no coast map")
+		}
+		return ElsaOnTheShores(in, layers).(*depthMap), nil
+	}, nil
+}
+
+func coastLayers(pc *PipelineContext) []CoastLayer {
+	var layers []CoastLayer
+	if pc.Cs != nil {
+		cp := pc.Coast
+		if cp.Name == "" {
+			cp = DefaultCoastParams
+		}
+		layers = append(layers, CoastLayer{Cs: pc.Cs, Params: cp})
+	}
+	if pc.Lakes != nil {
+		lp := pc.LakeCoast
+		if lp.Name == "" {
+			lp = CoastParamSets["lakes"]
+		}
+		layers = append(layers, CoastLayer{Cs: pc.Lakes, Params: lp})
+	}
+	return layers
+}
+
+// a coast point writes up to MaxStep - 1 points inland and reads MaxStep points from it
+func coastReach(args []string, pc *PipelineContext, g *Grid) int {
+	n := 0
+	for _, l := range coastLayers(pc) {
+		n = max(n, 2*l.Params.MaxStep)
+	}
+	return n
+}
+
+func opDem(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 0); err != nil {
+		return nil, err
+	}
+	return func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+		if pc.Elevation == nil {
+			pc.Logger.Warning("No DEM loaded, skipping elevation stage")
+			return in, nil
+		}
+		pc.Logger.Infof("Downscaling snow with DEM, %s", pc.SnowLine.String())
+		return DownscaleSnow(in, pc.Elevation, pc.SnowLine)
+	}, nil
+}
+
+// the points of a source cell are redistributed together
+func demReach(args []string, pc *PipelineContext, g *Grid) int {
+	if pc.Elevation == nil || pc.SnowLine.SourceRes <= 0 {
+		return 0
+	}
+	return int(math.Ceil(float64(pc.SnowLine.SourceRes / min(g.DLon, g.DLat))))
+}
+
+func opSeaIce(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 0); err != nil {
+		return nil, err
+	}
+	return func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+		if pc.SeaIce == nil {
+			pc.Logger.Warning("No sea ice loaded, skipping sea ice stage")
+			return in, nil
+		}
+		return pc.SeaIce.Apply(in)
+	}, nil
+}
+
+func opLandCover(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 0); err != nil {
+		return nil, err
+	}
+	return func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+		if pc.LandCover == nil {
+			pc.Logger.Warning("No land cover loaded, skipping land cover stage")
+			return in, nil
+		}
+		out, n := pc.LandCover.Correct(in)
+		pc.Logger.Infof("Land cover corrections on %d grid points", n)
+		return out, nil
+	}, nil
+}
+
+func opMaxMin(args []string, is_max bool) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	name := args[0]
+	return func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+		other, err := namedMapSampler(pc, in, name)
+		if err != nil {
+			return nil, err
+		}
+		return mapCells(in, in.name, func(i, j int, v float32) float32 {
+			if is_max {
+				return max(v, other(i, j))
+			}
+			return min(v, other(i, j))
+		}), nil
+	}, nil
+}
+
+func opMax(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	return opMaxMin(args, true)
+}
+
+func opMin(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	return opMaxMin(args, false)
+}
+
+func opBlend(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 2); err != nil {
+		return nil, err
+	}
+	name := args[0]
+	w, err := argFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if w < 0 || w > 1 {
+		return nil, fmt.Errorf("weight must be in [0, 1]")
+	}
+	return func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+		other, err := namedMapSampler(pc, in, name)
+		if err != nil {
+			return nil, err
+		}
+		return mapCells(in, in.name, func(i, j int, v float32) float32 {
+			return (1-w)*v + w*other(i, j)
+		}), nil
+	}, nil
+}
+
+func opScale(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	f, err := argFloat(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if f < 0 {
+		return nil, fmt.Errorf("negative factor")
+	}
+	return func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+		return mapCells(in, in.name, func(i, j int, v float32) float32 {
+			return v * f
+		}), nil
+	}, nil
+}
+
+func opThreshold(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	t, err := argFloat(args[0])
+	if err != nil {
+		return nil, err
+	}
+	return func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+		return mapCells(in, in.name, func(i, j int, v float32) float32 {
+			if v < t {
+				return 0
+			}
+			return v
+		}), nil
+	}, nil
+}
+
+func opMask(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	keep_land := true
+	switch strings.ToLower(args[0]) {
+	case "land":
+	case "water":
+		keep_land = false
+	default:
+		return nil, fmt.Errorf("mask must be 'land' or 'water'")
+	}
+	return func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+		if pc.Cs == nil {
+			return nil, fmt.Errorf("no coast map")
+		}
+		di, dj, ok := in.grid.offsetIn(&GlobalGrid)
+		if !ok {
+			return nil, fmt.Errorf("unsupported grid %s", in.grid.String())
+		}
+		return mapCells(in, in.name, func(i, j int, v float32) float32 {
+			if pc.Cs.IsWater(i+di, j+dj) == keep_land {
+				return 0
+			}
+			return v
+		}), nil
+	}, nil
+}
+
+func opBlur(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 {
+		return nil, fmt.Errorf("radius must be >= 1")
+	}
+	return func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+		return boxBlur(in, n), nil
+	}, nil
+}
+
+func blurReach(args []string, pc *PipelineContext, g *Grid) int {
+	n, _ := strconv.Atoi(args[0])
+	return n
+}
+
+// separable box filter, lon wraps around on wrapping grids, borders are clamped
+func boxBlur(in *depthMap, n int) *depthMap {
+	g := &in.grid
+	tmp := make([]float32, g.Size())
+	w := 1 / float32(2*n+1)
+
+	// in lat
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			s := float32(0)
+			for k := -n; k <= n; k++ {
+				s += in.getClamped(i, j+k)
+			}
+			tmp[i*g.NLat+j] = s * w
+		}
+	}
+
+	// in lon
+	dm := derivedMap(in, in.name)
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			s := float32(0)
+			for k := -n; k <= n; k++ {
+				ii, jj := g.clamp(i+k, j)
+				s += tmp[ii*g.NLat+jj]
+			}
+			dm.set(i, j, s*w)
+		}
+	}
+	return dm
+}
+
+func opSmooth(sigma, rng float32) func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+	f := newSmoothFilter(sigma, rng)
+	return func(pc *PipelineContext, in *depthMap) (*depthMap, error) {
+		var class func(i, j int) bool
+		if pc.Cs != nil {
+			if di, dj, ok := in.grid.offsetIn(&GlobalGrid); ok {
+				class = func(i, j int) bool {
+					return pc.Cs.IsWater(i+di, j+dj)
+				}
+			} else {
+				pc.Logger.Warningf("No land/water mask for grid %s", in.grid.String())
+			}
+		}
+		return smoothMap(in, f, class), nil
+	}
+}
+
+func opGauss(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 1); err != nil {
+		return nil, err
+	}
+	sigma, err := argFloat(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if sigma <= 0 || sigma > 20 {
+		return nil, fmt.Errorf("sigma must be in (0, 20]")
+	}
+	return opSmooth(sigma, 0), nil
+}
+
+func opBilateral(args []string) (func(pc *PipelineContext, in *depthMap) (*depthMap, error), error) {
+	if err := argCount(args, 2); err != nil {
+		return nil, err
+	}
+	sigma, err := argFloat(args[0])
+	if err != nil {
+		return nil, err
+	}
+	rng, err := argFloat(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if sigma <= 0 || sigma > 20 {
+		return nil, fmt.Errorf("sigma must be in (0, 20]")
+	}
+	if rng <= 0 {
+		return nil, fmt.Errorf("range must be > 0")
+	}
+	return opSmooth(sigma, rng), nil
+}
+
+func smoothReach(args []string, pc *PipelineContext, g *Grid) int {
+	sigma, _ := argFloat(args[0])
+	return smoothRadius(sigma)
+}
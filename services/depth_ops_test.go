@@ -0,0 +1,100 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func rampMap(name string) *depthMap {
+	m := newDepthMap(newMockLogger(), name, NewGrid(0, 0, 1, 1, 10, 10))
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			m.set(i, j, float32(i)*0.01)
+		}
+	}
+	return m
+}
+
+func TestParsePipeline(t *testing.T) {
+	p, err := ParsePipeline(" coast, scale:1.5 ,threshold:0.02,blend:raw:0.5")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(p.Ops))
+	assert.Equal(t, "coast,scale:1.5,threshold:0.02,blend:raw:0.5", p.String())
+
+	for _, spec := range []string{"foo", "scale", "scale:x", "blend:raw:2", "mask:ice", "blur:0", "coast:1"} {
+		_, err = ParsePipeline(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestPipelineReach(t *testing.T) {
+	pc := &PipelineContext{Logger: newMockLogger(), Cs: stubCoast{}}
+	p, err := ParsePipeline("dem,coast,blur:2,gauss:2,threshold:0.01")
+	assert.NoError(t, err)
+	assert.Equal(t, 2*3+2+5, p.Reach(pc, &GlobalGrid)) // no DEM loaded
+
+	pc.Coast = CoastParamSets["fjord"]
+	pc.Lakes = stubCoast{}
+	assert.Equal(t, 2*5+2+5, p.Reach(pc, &GlobalGrid))
+
+	pc.Elevation = &ElevationMap{}
+	pc.SnowLine = DefaultSnowLineModel
+	assert.Equal(t, 3+2*5+2+5, p.Reach(pc, &GlobalGrid))
+
+	p, _ = ParsePipeline("scale:2,mask:land")
+	assert.Equal(t, 0, p.Reach(pc, &GlobalGrid))
+}
+
+func TestPipelineRun(t *testing.T) {
+	in := rampMap("in")
+	other := newDepthMap(newMockLogger(), "other", NewGrid(0, 0, 1, 1, 10, 10))
+	other.set(0, 0, 1)
+
+	pc := &PipelineContext{Logger: newMockLogger(), Maps: map[string]DepthMap{"raw": in, "other": other}}
+
+	p, err := ParsePipeline("scale:2,threshold:0.05")
+	assert.NoError(t, err)
+	out, err := p.Run(pc, in)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(0), out.GetIdx(2, 5))
+	assert.InDelta(t, 0.06, out.GetIdx(3, 5), 1e-6)
+	assert.InDelta(t, 0.03, in.GetIdx(3, 5), 1e-6) // input is untouched
+
+	p, _ = ParsePipeline("max:other")
+	out, err = p.Run(pc, in)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(1), out.GetIdx(0, 0))
+	assert.InDelta(t, 0.05, out.GetIdx(5, 0), 1e-6)
+
+	p, _ = ParsePipeline("blend:other:0.25")
+	out, err = p.Run(pc, in)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.25, out.GetIdx(0, 0), 1e-6)
+	assert.InDelta(t, 0.75*0.05, out.GetIdx(5, 0), 1e-6)
+
+	// resampled from a map on another grid
+	coarse := newDepthMap(newMockLogger(), "coarse", NewGlobalGrid(10, 10))
+	coarse.set(0, 9, 1) // 0°, 0°
+	pc.Maps["coarse"] = coarse
+	p, _ = ParsePipeline("min:coarse")
+	out, err = p.Run(pc, in)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.05, out.GetIdx(5, 0), 1e-6)  // 0.05 < 0.5
+	assert.InDelta(t, 0.01, out.GetIdx(9, 9), 1e-6)  // 0.09 > 0.1 * 0.1
+	assert.InDelta(t, 0.04, out.GetIdx(4, 1), 0.002) // 0.04 < ~0.54
+
+	// a linear ramp stays the same inside
+	p, _ = ParsePipeline("blur:1")
+	out, err = p.Run(pc, in)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.05, out.GetIdx(5, 5), 1e-6)
+	assert.InDelta(t, 0.01/3, out.GetIdx(0, 5), 1e-6) // clamped border
+
+	p, _ = ParsePipeline("max:unknown")
+	_, err = p.Run(pc, in)
+	assert.Error(t, err)
+
+	p, _ = ParsePipeline("coast")
+	_, err = p.Run(pc, in)
+	assert.Error(t, err)
+}
@@ -0,0 +1,209 @@
+package services
+
+import (
+	"math"
+	"sort"
+)
+
+const lat2m = 111120 // 1° lat in m
+
+// depth from which a grid point counts as snow covered, same as the first entry of the snow table
+const snowCoveredDepth = 0.01
+
+type LatLon struct {
+	Lat, Lon float32
+}
+
+// Statistics of the grid points within an area. Grid points are weighted with cos(lat),
+// the area they represent.
+type AreaStats struct {
+	N              int     // # of grid points, 0 if the area is smaller than a grid cell (then the center is sampled)
+	Mean, Min, Max float32 // m
+	Covered        float32 // area fraction with snow depth >= 1 cm
+
+	samples []weightedDepth // sorted by depth
+	weight  float64
+}
+
+type weightedDepth struct {
+	depth, weight float32
+}
+
+func (s *AreaStats) add(sd, w float32) {
+	s.samples = append(s.samples, weightedDepth{sd, w})
+}
+
+func (s *AreaStats) finish() {
+	if len(s.samples) == 0 {
+		return
+	}
+
+	sort.Slice(s.samples, func(i, j int) bool { return s.samples[i].depth < s.samples[j].depth })
+	s.Min = s.samples[0].depth
+	s.Max = s.samples[len(s.samples)-1].depth
+
+	sum, covered := float64(0), float64(0)
+	for _, x := range s.samples {
+		s.weight += float64(x.weight)
+		sum += float64(x.depth * x.weight)
+		if x.depth >= snowCoveredDepth {
+			covered += float64(x.weight)
+		}
+	}
+	if s.weight > 0 {
+		s.Mean = float32(sum / s.weight)
+		s.Covered = float32(covered / s.weight)
+	}
+}
+
+// area weighted percentile, p in [0, 100]
+func (s *AreaStats) Percentile(p float32) float32 {
+	if len(s.samples) == 0 {
+		return 0
+	}
+	target := float64(p) / 100 * s.weight
+	acc := float64(0)
+	for _, x := range s.samples {
+		acc += float64(x.weight)
+		if acc >= target {
+			return x.depth
+		}
+	}
+	return s.Max
+}
+
+// great circle distance in m
+func distance(lat1, lon1, lat2, lon2 float32) float32 {
+	const d2r = math.Pi / 180
+	p1, p2 := float64(lat1)*d2r, float64(lat2)*d2r
+	dp := p2 - p1
+	dl := float64(lon2-lon1) * d2r
+	a := math.Sin(dp/2)*math.Sin(dp/2) + math.Cos(p1)*math.Cos(p2)*math.Sin(dl/2)*math.Sin(dl/2)
+	return float32(2 * math.Asin(math.Sqrt(min(1, a))) * 180 / math.Pi * lat2m)
+}
+
+// visit grid points in a lon/lat box, lon may be unwrapped (beyond ±180)
+func forGridPoints(g *Grid, west, south, east, north float32, f func(iLon, iLat int, lon, lat float32)) {
+	fw, fs := g.FIdx(west, south)
+	if !g.WrapLon && fw*g.DLon >= 180 {
+		fw -= 360 / g.DLon // west of Lon0
+	}
+	iw := int(math.Ceil(float64(fw)))
+	js := max(0, int(math.Ceil(float64(fs))))
+	nLon := int(math.Floor(float64((east-west)/g.DLon))) + 1
+	if east-west >= 360 {
+		nLon = g.NLon
+	}
+	jn := min(g.NLat-1, int(math.Floor(float64((north-g.Lat0)/g.DLat))))
+
+	for k := 0; k < nLon; k++ {
+		iLon := iw + k
+		if g.WrapLon {
+			iLon %= g.NLon
+		} else if iLon < 0 {
+			continue
+		} else if iLon >= g.NLon {
+			break
+		}
+		// lon as seen from west so it stays comparable with unwrapped coordinates
+		lon := west + (float32(iw+k)-fw)*g.DLon
+		for iLat := js; iLat <= jn; iLat++ {
+			_, lat := g.LonLat(iLon, iLat)
+			f(iLon, iLat, lon, lat)
+		}
+	}
+}
+
+// grid points without a valid depth (NaN or the grid's NoData) don't count
+func sampleDepth(dm DepthMap, iLon, iLat int) (float32, bool) {
+	v := dm.GetIdx(iLon, iLat)
+	if isNoData(dm.Grid(), v) {
+		return 0, false
+	}
+	return max(0, v), true
+}
+
+// statistics for grid points within radius m of (lat, lon)
+func StatsInRadius(dm DepthMap, lat, lon, radius float32) *AreaStats {
+	g := dm.Grid()
+	s := &AreaStats{}
+
+	dlat := radius / lat2m
+	cl := float32(math.Cos(float64(min(89.9, max(-89.9, abs32(lat)+dlat))) * math.Pi / 180))
+	dlon := min(180, dlat/cl)
+
+	forGridPoints(g, lon-dlon, lat-dlat, lon+dlon, lat+dlat, func(iLon, iLat int, plon, plat float32) {
+		if distance(lat, lon, plat, plon) > radius {
+			return
+		}
+		if sd, ok := sampleDepth(dm, iLon, iLat); ok {
+			s.add(sd, float32(math.Cos(float64(plat)*math.Pi/180)))
+		}
+	})
+
+	s.N = len(s.samples)
+	if s.N == 0 {
+		s.add(max(0, dm.Get(lon, lat)), 1)
+	}
+	s.finish()
+	return s
+}
+
+func abs32(x float32) float32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// statistics for grid points within a polygon, vertices in order, the polygon may cross the antimeridian
+func StatsInPolygon(dm DepthMap, poly []LatLon) *AreaStats {
+	s := &AreaStats{}
+	if len(poly) < 3 {
+		s.finish()
+		return s
+	}
+
+	// unwrap lon relative to the first vertex
+	pts := make([]LatLon, len(poly))
+	west, east := float32(math.MaxFloat32), float32(-math.MaxFloat32)
+	south, north := float32(90), float32(-90)
+	c := LatLon{}
+	for i, p := range poly {
+		d := float32(math.Mod(float64(p.Lon-poly[0].Lon)+540, 360) - 180)
+		pts[i] = LatLon{p.Lat, poly[0].Lon + d}
+		west, east = min(west, pts[i].Lon), max(east, pts[i].Lon)
+		south, north = min(south, p.Lat), max(north, p.Lat)
+		c.Lat += p.Lat / float32(len(poly))
+		c.Lon += pts[i].Lon / float32(len(poly))
+	}
+
+	forGridPoints(dm.Grid(), west, south, east, north, func(iLon, iLat int, plon, plat float32) {
+		if !pointInPolygon(pts, plat, plon) {
+			return
+		}
+		if sd, ok := sampleDepth(dm, iLon, iLat); ok {
+			s.add(sd, float32(math.Cos(float64(plat)*math.Pi/180)))
+		}
+	})
+
+	s.N = len(s.samples)
+	if s.N == 0 {
+		s.add(max(0, dm.Get(c.Lon, c.Lat)), 1)
+	}
+	s.finish()
+	return s
+}
+
+// even-odd rule
+func pointInPolygon(pts []LatLon, lat, lon float32) bool {
+	in := false
+	for i, j := 0, len(pts)-1; i < len(pts); j, i = i, i+1 {
+		a, b := pts[i], pts[j]
+		if (a.Lat > lat) != (b.Lat > lat) &&
+			lon < (b.Lon-a.Lon)*(lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			in = !in
+		}
+	}
+	return in
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestStatsInRadius(t *testing.T) {
+	m := newDepthMap(newMockLogger(), "Snow", GlobalGrid)
+	// 0.5 m at the center point, 0.1 m around it
+	i0, j0, _ := GlobalGrid.Idx(9.3, 51.4)
+	for i := i0 - 5; i <= i0+5; i++ {
+		for j := j0 - 5; j <= j0+5; j++ {
+			m.set(i, j, 0.1)
+		}
+	}
+	m.set(i0, j0, 0.5)
+
+	// only the center
+	s := StatsInRadius(m, 51.4, 9.3, 1000)
+	assert.Equal(t, 1, s.N)
+	assert.InDelta(t, 0.5, s.Mean, 1e-6)
+
+	// 0.1° lon at 51.4° is ~6.9 km, 0.1° lat ~11.1 km, so 3 x 1 points within 8 km
+	s = StatsInRadius(m, 51.4, 9.3, 8000)
+	assert.Equal(t, 3, s.N)
+	assert.InDelta(t, 0.5, s.Max, 1e-6)
+	assert.InDelta(t, 0.1, s.Min, 1e-6)
+	assert.InDelta(t, 0.7/3, s.Mean, 1e-4)
+	assert.InDelta(t, 0.1, s.Percentile(50), 1e-6)
+	assert.InDelta(t, 0.5, s.Percentile(100), 1e-6)
+	assert.InDelta(t, 1, s.Covered, 1e-6)
+
+	// reaches into the snow free area
+	s = StatsInRadius(m, 51.4, 9.3, 100000)
+	assert.Greater(t, s.N, 121)
+	assert.Less(t, s.Covered, float32(0.9))
+	assert.Equal(t, float32(0), s.Min)
+
+	// smaller than a grid cell between grid points: interpolated center
+	s = StatsInRadius(m, 51.45, 9.35, 100)
+	assert.Equal(t, 0, s.N)
+	assert.InDelta(t, 0.2, s.Mean, 1e-5)
+}
+
+func TestStatsWrap(t *testing.T) {
+	m := newDepthMap(newMockLogger(), "Snow", GlobalGrid)
+	_, j0, _ := GlobalGrid.Idx(0, 60)
+	i180, _, _ := GlobalGrid.Idx(180, 60)
+	for j := j0 - 2; j <= j0+2; j++ {
+		m.set(GlobalGrid.NLon-1, j, 0.2) // -0.1°
+		m.set(0, j, 0.4)
+		m.set(1, j, 0.6)
+		m.set(i180-1, j, 0.2)
+		m.set(i180, j, 0.4)
+		m.set(i180+1, j, 0.6)
+	}
+
+	// 0.1° lon at 60° is ~5.6 km
+	s := StatsInRadius(m, 60, 0, 6000)
+	assert.Equal(t, 3, s.N)
+	assert.InDelta(t, 0.4, s.Mean, 1e-5)
+
+	// box across the antimeridian
+	box := []LatLon{{59.95, 179.85}, {59.95, -179.85}, {60.05, -179.85}, {60.05, 179.85}}
+	s = StatsInPolygon(m, box)
+	assert.Equal(t, 3, s.N)
+	assert.InDelta(t, 0.2, s.Min, 1e-6)
+	assert.InDelta(t, 0.6, s.Max, 1e-6)
+}
+
+func TestStatsNoData(t *testing.T) {
+	g := NewGrid(9, 51, 0.1, 0.1, 11, 11)
+	g.NoData = -1
+	m := newDepthMap(newMockLogger(), "Regional", g)
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			m.set(i, j, 0.2)
+		}
+	}
+	for j := 0; j < g.NLat; j++ {
+		m.set(5, j, -1) // 9.5°
+	}
+
+	// 0.1° lon at 51.5° is ~6.9 km, so 3 points of which the center has no data
+	s := StatsInRadius(m, 51.5, 9.5, 8000)
+	assert.Equal(t, 2, s.N)
+	assert.InDelta(t, 0.2, s.Min, 1e-6)
+	assert.InDelta(t, 1, s.Covered, 1e-6)
+}
+
+func TestStatsInPolygon(t *testing.T) {
+	m := rampMap("ramp")
+	g := m.Grid()
+
+	// triangle with the right angle at the origin of the ramp grid
+	lon0, lat0 := g.LonLat(0, 0)
+	tri := []LatLon{
+		{lat0 - 0.01, lon0 - 0.01},
+		{lat0 - 0.01, lon0 + 4.05*g.DLon},
+		{lat0 + 4.05*g.DLat, lon0 - 0.01},
+	}
+	s := StatsInPolygon(m, tri)
+	assert.Equal(t, 15, s.N) // 5 + 4 + 3 + 2 + 1
+
+	assert.True(t, pointInPolygon(tri, lat0, lon0))
+	assert.False(t, pointInPolygon(tri, lat0+3*g.DLat, lon0+3*g.DLon))
+
+	// degenerated
+	s = StatsInPolygon(m, tri[:2])
+	assert.Equal(t, 0, s.N)
+}
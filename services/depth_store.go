@@ -0,0 +1,219 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// How the values of a depth map are held in memory.
+// A global 0.1° map is 6.5M values, as float32 that's ~26 MB per map.
+type Storage int
+
+const (
+	StorageFloat     Storage = iota // plain float32
+	StorageQuantized                // uint16 in mm, 0..65.534 m, ~13 MB
+	StorageSparse                   // float32 blocks, blocks without snow are not allocated
+)
+
+func ParseStorage(s string) (Storage, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "float":
+		return StorageFloat, nil
+	case "quantized":
+		return StorageQuantized, nil
+	case "sparse":
+		return StorageSparse, nil
+	}
+	return StorageFloat, fmt.Errorf("unknown depth map storage '%s'", s)
+}
+
+func (s Storage) String() string {
+	return [...]string{"float", "quantized", "sparse"}[s]
+}
+
+type depthStore interface {
+	get(iLon, iLat int) float32
+	set(iLon, iLat int, v float32)
+	memSize() int // bytes used for values
+	clone() depthStore
+
+	// set can be called concurrently for distinct points
+	concurrentSet() bool
+}
+
+// all values are initialized with fill
+func newDepthStore(storage Storage, grid *Grid, fill float32) depthStore {
+	switch storage {
+	case StorageQuantized:
+		return newQuantizedStore(grid, fill)
+	case StorageSparse:
+		return newSparseStore(grid, fill)
+	}
+	return newFloatStore(grid, fill)
+}
+
+// plain float32
+type floatStore struct {
+	nLat int
+	val  []float32 // [iLon * NLat + iLat]
+}
+
+func newFloatStore(grid *Grid, fill float32) *floatStore {
+	s := &floatStore{nLat: grid.NLat, val: make([]float32, grid.Size())}
+	if fill != 0 {
+		for i := range s.val {
+			s.val[i] = fill
+		}
+	}
+	return s
+}
+
+func (s *floatStore) get(iLon, iLat int) float32 {
+	return s.val[iLon*s.nLat+iLat]
+}
+
+func (s *floatStore) set(iLon, iLat int, v float32) {
+	s.val[iLon*s.nLat+iLat] = v
+}
+
+func (s *floatStore) memSize() int {
+	return 4 * len(s.val)
+}
+
+func (s *floatStore) clone() depthStore {
+	return &floatStore{nLat: s.nLat, val: slices.Clone(s.val)}
+}
+
+func (s *floatStore) concurrentSet() bool {
+	return true
+}
+
+// snow depth in mm, the highest code is reserved for the map's NoData value
+const qNoData = math.MaxUint16
+const qMax = float32(qNoData-1) / 1000
+
+type quantizedStore struct {
+	nLat   int
+	noData float32
+	val    []uint16
+}
+
+func newQuantizedStore(grid *Grid, fill float32) *quantizedStore {
+	s := &quantizedStore{nLat: grid.NLat, noData: grid.NoData, val: make([]uint16, grid.Size())}
+	if q := s.quantize(fill); q != 0 {
+		for i := range s.val {
+			s.val[i] = q
+		}
+	}
+	return s
+}
+
+func (s *quantizedStore) quantize(v float32) uint16 {
+	if v == s.noData && v != 0 {
+		return qNoData
+	}
+	if !(v > 0) { // negative and NaN
+		return 0
+	}
+	if v >= qMax {
+		return qNoData - 1
+	}
+	return uint16(v*1000 + 0.5)
+}
+
+func (s *quantizedStore) get(iLon, iLat int) float32 {
+	q := s.val[iLon*s.nLat+iLat]
+	if q == qNoData {
+		return s.noData
+	}
+	return float32(q) / 1000
+}
+
+func (s *quantizedStore) set(iLon, iLat int, v float32) {
+	s.val[iLon*s.nLat+iLat] = s.quantize(v)
+}
+
+func (s *quantizedStore) memSize() int {
+	return 2 * len(s.val)
+}
+
+func (s *quantizedStore) clone() depthStore {
+	return &quantizedStore{nLat: s.nLat, noData: s.noData, val: slices.Clone(s.val)}
+}
+
+func (s *quantizedStore) concurrentSet() bool {
+	return true
+}
+
+// 32x32 blocks = 3.2° x 3.2° on the 0.1° grid, most of them have no snow at all
+const sparseBlockShift = 5
+const sparseBlockDim = 1 << sparseBlockShift
+const sparseBlockMask = sparseBlockDim - 1
+
+type sparseBlock [sparseBlockDim * sparseBlockDim]float32
+
+type sparseStore struct {
+	nbLat  int // # of blocks in lat
+	fill   float32
+	blocks []*sparseBlock // [ibLon * nbLat + ibLat]
+}
+
+func newSparseStore(grid *Grid, fill float32) *sparseStore {
+	nbLon := (grid.NLon + sparseBlockMask) >> sparseBlockShift
+	nbLat := (grid.NLat + sparseBlockMask) >> sparseBlockShift
+	return &sparseStore{nbLat: nbLat, fill: fill, blocks: make([]*sparseBlock, nbLon*nbLat)}
+}
+
+func (s *sparseStore) get(iLon, iLat int) float32 {
+	b := s.blocks[(iLon>>sparseBlockShift)*s.nbLat+(iLat>>sparseBlockShift)]
+	if b == nil {
+		return s.fill
+	}
+	return b[(iLon&sparseBlockMask)<<sparseBlockShift|(iLat&sparseBlockMask)]
+}
+
+func (s *sparseStore) set(iLon, iLat int, v float32) {
+	bi := (iLon>>sparseBlockShift)*s.nbLat + (iLat >> sparseBlockShift)
+	b := s.blocks[bi]
+	if b == nil {
+		if v == s.fill {
+			return
+		}
+		b = new(sparseBlock)
+		if s.fill != 0 {
+			for i := range b {
+				b[i] = s.fill
+			}
+		}
+		s.blocks[bi] = b
+	}
+	b[(iLon&sparseBlockMask)<<sparseBlockShift|(iLat&sparseBlockMask)] = v
+}
+
+func (s *sparseStore) memSize() int {
+	n := 8 * len(s.blocks)
+	for _, b := range s.blocks {
+		if b != nil {
+			n += 4 * len(b)
+		}
+	}
+	return n
+}
+
+func (s *sparseStore) clone() depthStore {
+	c := &sparseStore{nbLat: s.nbLat, fill: s.fill, blocks: make([]*sparseBlock, len(s.blocks))}
+	for i, b := range s.blocks {
+		if b != nil {
+			nb := *b
+			c.blocks[i] = &nb
+		}
+	}
+	return c
+}
+
+// blocks are allocated on demand
+func (s *sparseStore) concurrentSet() bool {
+	return false
+}
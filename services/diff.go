@@ -0,0 +1,221 @@
+package services
+
+import (
+	"fmt"
+	"github.com/xairline/xa-snow/utils/exporter"
+	"github.com/xairline/xa-snow/utils/logger"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDiffThreshold = 0.05 // m
+	diffRegionSize       = 5    // °
+	diffRegions          = 10   // reported
+)
+
+// change in a diffRegionSize° box
+type RegionChange struct {
+	West, South  float32
+	Mean         float32 // mean change in m, weighted by cell area
+	Gained, Lost int     // cells
+}
+
+// summary of b - a
+type DiffStats struct {
+	A, B                 string
+	Threshold            float32 // m
+	Cells                int     // compared
+	Gained, Lost         int     // snow appeared / disappeared
+	Increased, Decreased int     // changed by more than Threshold
+	MaxIncrease          float32
+	MaxIncreaseAt        LatLon
+	MaxDecrease          float32 // <= 0
+	MaxDecreaseAt        LatLon
+	Regions              []RegionChange // largest change of volume first
+}
+
+func (s *DiffStats) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "'%s' -> '%s': %d cells, %d gained snow, %d lost snow\n", s.A, s.B, s.Cells, s.Gained, s.Lost)
+	fmt.Fprintf(&b, "changed by more than %0.3f m: %d increased, %d decreased\n", s.Threshold, s.Increased, s.Decreased)
+	fmt.Fprintf(&b, "max increase: %0.3f m at %0.2f/%0.2f, max decrease: %0.3f m at %0.2f/%0.2f\n",
+		s.MaxIncrease, s.MaxIncreaseAt.Lat, s.MaxIncreaseAt.Lon, s.MaxDecrease, s.MaxDecreaseAt.Lat, s.MaxDecreaseAt.Lon)
+	for _, r := range s.Regions {
+		fmt.Fprintf(&b, "region %0.0f/%0.0f - %0.0f/%0.0f: mean %+0.3f m, %d gained, %d lost\n",
+			r.South, r.West, r.South+diffRegionSize, r.West+diffRegionSize, r.Mean, r.Gained, r.Lost)
+	}
+	return b.String()
+}
+
+func isNoData(g *Grid, v float32) bool {
+	return (v == g.NoData && v != 0) || v != v
+}
+
+// Difference b - a on the grid of a, b is interpolated if its grid is different. Points that are
+// undefined in either map are NoData in the result.
+func DiffMaps(logger logger.Logger, a, b DepthMap, threshold float32) (DepthMap, *DiffStats) {
+	g := a.Grid()
+	bg := b.Grid()
+	same := *g == *bg
+
+	diff := newDepthMap(logger, fmt.Sprintf("%s - %s", b.Name(), a.Name()), *g)
+	undef := g.NoData
+	if undef == 0 {
+		undef = float32(math.NaN())
+	}
+
+	s := &DiffStats{A: a.Name(), B: b.Name(), Threshold: threshold}
+	type region struct {
+		sum, w       float64
+		gained, lost int
+	}
+	regions := make(map[[2]int]*region)
+
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			lon, lat := g.LonLat(i, j)
+			va := a.GetIdx(i, j)
+			var vb float32
+			if same {
+				vb = b.GetIdx(i, j)
+			} else {
+				vb = b.Get(lon, lat)
+			}
+			if isNoData(g, va) || isNoData(bg, vb) {
+				diff.set(i, j, undef)
+				continue
+			}
+
+			d := vb - va
+			diff.set(i, j, d)
+			s.Cells++
+
+			lon = float32(math.Mod(float64(lon)+540, 360) - 180)
+			k := [2]int{int(math.Floor(float64(lon) / diffRegionSize)), int(math.Floor(float64(lat) / diffRegionSize))}
+			r := regions[k]
+			if r == nil {
+				r = &region{}
+				regions[k] = r
+			}
+			w := math.Cos(float64(lat) * math.Pi / 180)
+			r.sum += w * float64(d)
+			r.w += w
+
+			switch {
+			case va < snowCoveredDepth && vb >= snowCoveredDepth:
+				s.Gained++
+				r.gained++
+			case va >= snowCoveredDepth && vb < snowCoveredDepth:
+				s.Lost++
+				r.lost++
+			}
+			if d > threshold {
+				s.Increased++
+			} else if d < -threshold {
+				s.Decreased++
+			}
+			if d > s.MaxIncrease {
+				s.MaxIncrease, s.MaxIncreaseAt = d, LatLon{lat, lon}
+			}
+			if d < s.MaxDecrease {
+				s.MaxDecrease, s.MaxDecreaseAt = d, LatLon{lat, lon}
+			}
+		}
+	}
+
+	// by volume, the weight of the polar boxes is small
+	type ranked struct {
+		RegionChange
+		volume float64
+	}
+	var rs []ranked
+	for k, r := range regions {
+		if r.sum == 0 && r.gained == 0 && r.lost == 0 {
+			continue
+		}
+		rs = append(rs, ranked{RegionChange{
+			West: float32(k[0] * diffRegionSize), South: float32(k[1] * diffRegionSize),
+			Mean: float32(r.sum / r.w), Gained: r.gained, Lost: r.lost}, math.Abs(r.sum)})
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].volume != rs[j].volume {
+			return rs[i].volume > rs[j].volume
+		}
+		if rs[i].South != rs[j].South {
+			return rs[i].South < rs[j].South
+		}
+		return rs[i].West < rs[j].West
+	})
+	for i := 0; i < len(rs) && i < diffRegions; i++ {
+		s.Regions = append(s.Regions, rs[i].RegionChange)
+	}
+	return diff, s
+}
+
+// Compare the current snow map with base and write the difference as PNG and GeoTIFF and the
+// statistics as text into dir. base is "raw" for the map as loaded, "previous" for the last cycle
+// in the history or the cycle as yyyymmddhh.
+func (g *gribService) ExportDiff(dir string, base string) ([]string, error) {
+	snap := g.snapshots.Load()
+	if snap == nil {
+		return nil, fmt.Errorf("no snow data available")
+	}
+
+	a, b := snap.Raw, Materialize(snap.Snow)
+	if base != "raw" {
+		h := g.History()
+		if h == nil {
+			return nil, fmt.Errorf("snow history is disabled")
+		}
+		t := snap.CycleTime.Add(-time.Minute)
+		if base != "previous" {
+			var err error
+			if t, err = time.Parse(historyTimeFormat, base); err != nil {
+				return nil, fmt.Errorf("invalid cycle '%s'", base)
+			}
+		}
+		dm, cycle, err := h.Load(t)
+		if err != nil {
+			return nil, err
+		}
+		g.Logger.Infof("Comparing with cycle %s", cycle.Format(historyTimeFormat))
+		a = dm
+	}
+
+	threshold := envFloat(g.Logger, "DIFF_THRESHOLD", DefaultDiffThreshold)
+	diff, stats := DiffMaps(g.Logger, a, b, threshold)
+	g.Logger.Infof("Snow diff %s", stats.String())
+
+	opt := &exporter.Options{Ramp: exporter.DiffRamp}
+	if s := os.Getenv("EXPORT_BBOX"); s != "" {
+		bb, err := exporter.ParseBBox(s)
+		if err != nil {
+			return nil, err
+		}
+		opt.BBox = bb
+	}
+
+	name := "xa-snow_diff_" + base
+	var files []string
+	for _, ext := range []string{".png", ".tif"} {
+		fn := filepath.Join(dir, name+ext)
+		if err := exporter.ExportFile(fn, ExportRaster(diff), opt); err != nil {
+			return files, err
+		}
+		files = append(files, fn)
+	}
+	fn := filepath.Join(dir, name+".txt")
+	if err := os.WriteFile(fn, []byte(stats.String()), 0644); err != nil {
+		return files, err
+	}
+	files = append(files, fn)
+	for _, f := range files {
+		g.Logger.Infof("Exported '%s'", f)
+	}
+	return files, nil
+}
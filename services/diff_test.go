@@ -0,0 +1,64 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestDiffMaps(t *testing.T) {
+	a := historyMap(0)
+	b := historyMap(0)
+	g := a.grid
+	a.set(20, 100, 0.2) // lost
+	b.set(30, 100, 0.3) // gained
+	b.set(10, 140, 0.2)
+	a.set(40, 100, 0.5) // decreased but still covered
+	b.set(40, 100, 0.4)
+
+	diff, s := DiffMaps(newMockLogger(), a, b, 0.05)
+	assert.Equal(t, g.Size(), s.Cells)
+	assert.Equal(t, 1, s.Gained)
+	assert.Equal(t, 1, s.Lost)
+	assert.Equal(t, 1, s.Increased)
+	assert.Equal(t, 3, s.Decreased)
+	assert.InDelta(t, 0.3, s.MaxIncrease, 1e-6)
+	assert.Equal(t, LatLon{10, 30}, s.MaxIncreaseAt)
+	assert.InDelta(t, -0.2, s.MaxDecrease, 1e-6)
+	assert.Equal(t, "Snow - Snow", diff.Name())
+	assert.InDelta(t, -0.1, diff.GetIdx(10, 140), 1e-6)
+	assert.InDelta(t, 0.3, diff.GetIdx(30, 100), 1e-6)
+	assert.Equal(t, float32(0), diff.GetIdx(0, 0))
+
+	// the box with the biggest change first
+	assert.Equal(t, 4, len(s.Regions))
+	assert.Equal(t, float32(30), s.Regions[0].West)
+	assert.Equal(t, float32(10), s.Regions[0].South)
+	assert.Equal(t, 1, s.Regions[0].Gained)
+	assert.InDelta(t, 0.3/25, s.Regions[0].Mean, 1e-3)
+	assert.Contains(t, s.String(), "1 gained snow, 1 lost snow")
+
+	// different grids, undefined points
+	r := newDepthMap(newMockLogger(), "Regional", NewGrid(25, 5, 1, 1, 10, 10))
+	r.grid.NoData = -1
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			r.set(i, j, 0.1)
+		}
+	}
+	r.set(0, 0, -1)
+	diff, s = DiffMaps(newMockLogger(), r, b, 0.05)
+	assert.Equal(t, 99, s.Cells)
+	assert.Equal(t, float32(-1), diff.GetIdx(0, 0))
+	assert.InDelta(t, 0.2, diff.GetIdx(5, 5), 1e-6) // 30/10
+	assert.Equal(t, 1, s.Increased)
+	assert.Equal(t, 98, s.Decreased)
+	assert.Equal(t, 98, s.Lost)
+
+	// global NoData 0: undefined points are NaN
+	c := historyMap(0)
+	c.set(5, 5, float32(math.NaN()))
+	diff, s = DiffMaps(newMockLogger(), a, c, 0.05)
+	assert.Equal(t, g.Size()-1, s.Cells)
+	assert.True(t, math.IsNaN(float64(diff.GetIdx(5, 5))))
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"fmt"
+	"github.com/xairline/xa-snow/utils/exporter"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type exportRaster struct {
+	DepthMap
+}
+
+func (r exportRaster) Geometry() exporter.Geometry {
+	g := r.Grid()
+	return exporter.Geometry{Lon0: g.Lon0, Lat0: g.Lat0, DLon: g.DLon, DLat: g.DLat,
+		NLon: g.NLon, NLat: g.NLat, WrapLon: g.WrapLon, NoData: g.NoData}
+}
+
+// view of a depth map for the exporters
+func ExportRaster(dm DepthMap) exporter.Raster {
+	return exportRaster{dm}
+}
+
+// write the current snow map as PNG, GeoTIFF, ESRI ASCII grid and GeoJSON contours into dir
+// EXPORT_BBOX=west,south,east,north limits the area, EXPORT_CONTOURS and EXPORT_SIMPLIFY
+// set the contour thresholds in m and the tolerance of the simplification in °
+func (g *gribService) ExportSnow(dir string) ([]string, error) {
+	snap := g.snapshots.Load()
+	if snap == nil {
+		return nil, fmt.Errorf("no snow data available")
+	}
+
+	opt := &exporter.Options{}
+	if s := os.Getenv("EXPORT_BBOX"); s != "" {
+		bb, err := exporter.ParseBBox(s)
+		if err != nil {
+			return nil, err
+		}
+		opt.BBox = bb
+	}
+	if s := os.Getenv("EXPORT_CONTOURS"); s != "" {
+		th, err := exporter.ParseThresholds(s)
+		if err != nil {
+			return nil, err
+		}
+		opt.Thresholds = th
+	}
+	opt.Simplify = float64(envFloat(g.Logger, "EXPORT_SIMPLIFY", 0.02))
+
+	base := "xa-snow_" + strings.TrimSuffix(filepath.Base(snap.Source), filepath.Ext(snap.Source))
+	snow := Materialize(snap.Snow)
+	var files []string
+	for _, ext := range []string{".png", ".tif", ".asc", ".geojson"} {
+		fn := filepath.Join(dir, base+ext)
+		if err := exporter.ExportFile(fn, ExportRaster(snow), opt); err != nil {
+			return files, err
+		}
+		g.Logger.Infof("Exported '%s'", fn)
+		files = append(files, fn)
+	}
+
+	// how the map was made
+	fn := filepath.Join(dir, base+".txt")
+	if err := os.WriteFile(fn, []byte(snap.Provenance()+"\n"), 0644); err != nil {
+		return files, err
+	}
+	g.Logger.Infof("Exported '%s'", fn)
+	files = append(files, fn)
+	return files, nil
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"fmt"
+	"github.com/xairline/xa-snow/utils/coast"
 	"github.com/xairline/xa-snow/utils/logger"
 	"io"
 	"net/http"
@@ -19,24 +20,53 @@ type GribService interface {
 	IsReady() bool                                                                              // ready to retrieve values
 	DownloadAndProcessGribFile(sys_time bool, day, month, hour int) (error, DepthMap, DepthMap) // -> err, gribSnow, coastalSnow
 	GetSnowDepth(lat, lon float32) float32
-	convertGribToCsv(snow_csv_name string)
+	GetSnowStats(lat, lon, radius float32) *AreaStats // radius in m, nil if not ready
+	Track(lat, lon, track float32)                    // aircraft position and true track, prefetches tiles
+	convertGribToCsv(snow_csv_name string) error
 	downloadGribFile(sys_time bool, day, month, hour int) (string, error)
-	getDownloadUrl(sys_time bool, timeUTC time.Time) (string, time.Time, int)
+	getDownloadUrl(sys_time bool, timeUTC time.Time) (string, time.Time, int, int)
 	SetNotReady()
+	Snapshot() *SnowSnapshot                       // nil if not ready
+	GetArchiveManifest() (*ArchiveManifest, error) // nil, nil if no manifest is configured
+	ExportSnow(dir string) ([]string, error)       // -> files written
+	ExportDiff(dir, base string) ([]string, error) // base: "raw", "previous" or yyyymmddhh
+	History() *SnowHistory                         // nil if disabled
 }
 
 type gribService struct {
-	ready          bool
 	Logger         logger.Logger
 	gribFilePath   string
+	gribCycleTime  time.Time
 	gribFileFolder string
 	binPath        string
+	csLock         sync.Mutex
 	cs             CoastService
-	SnowDm         DepthMap
+	lakes          *goCoastService // from SNOW_LAKES, loaded on first use
+	lakes_path     string
+	snapshots      snapshotPublisher
+
+	archiveLock   sync.Mutex
+	archive       *ArchiveManifest
+	archiveErr    error // of the last load
+	archiveFailed time.Time
+
+	elev      *ElevationMap // from SNOW_DEM, loaded on first use
+	elev_path string
+
+	lc      *LandCoverMap // from SNOW_LANDCOVER, loaded on first use
+	lc_path string
+
+	histLock sync.Mutex
+	hist     *SnowHistory // opened on first use
 }
 
+// a download that is still running won't publish its result
 func (g *gribService) SetNotReady() {
-	g.ready = false
+	g.snapshots.Invalidate()
+}
+
+func (g *gribService) Snapshot() *SnowSnapshot {
+	return g.snapshots.Load()
 }
 
 var gribSvcLock = &sync.Mutex{}
@@ -66,20 +96,82 @@ func NewGribService(logger logger.Logger, dir string, binPath string, cs CoastSe
 	}
 }
 
+// the manifest location is taken from ARCHIVE_MANIFEST (file or http(s) url)
+// a failed load is remembered for archiveRetry
+func (g *gribService) GetArchiveManifest() (*ArchiveManifest, error) {
+	g.archiveLock.Lock()
+	m, err, failed := g.archive, g.archiveErr, g.archiveFailed
+	g.archiveLock.Unlock()
+
+	if m != nil {
+		return m, nil
+	}
+	if err != nil && time.Since(failed) < archiveRetry {
+		return nil, err
+	}
+
+	src := os.Getenv("ARCHIVE_MANIFEST")
+	if src == "" {
+		return nil, nil
+	}
+
+	// not under the lock so a slow mirror doesn't block other callers,
+	// rarely the manifest may be loaded twice
+	m, err = LoadArchiveManifest(src)
+
+	g.archiveLock.Lock()
+	defer g.archiveLock.Unlock()
+	if err != nil {
+		g.Logger.Errorf("Can't load archive manifest: %v", err)
+		g.archiveErr, g.archiveFailed = err, time.Now()
+		return nil, err
+	}
+
+	g.Logger.Infof("Loaded archive manifest '%s' with %d entries", src, len(m.Entries))
+	g.archive, g.archiveErr = m, nil
+	return m, nil
+}
+
 func (g *gribService) IsReady() bool {
-	return g.ready
+	return g.snapshots.Load() != nil
 }
 
 func (g *gribService) GetSnowDepth(lat, lon float32) float32 {
-	if !g.ready {
+	snap := g.snapshots.Load()
+	if snap == nil {
 		g.Logger.Error("Get called and service is not ready!")
 		return 0.0
 	}
 
-	return g.SnowDm.Get(lon, lat)
+	sd := snap.Snow.Get(lon, lat)
+	if snap.Noise != nil {
+		sd = snap.Noise.Apply(lon, lat, sd)
+	}
+	return sd
+}
+
+func (g *gribService) Track(lat, lon, track float32) {
+	snap := g.snapshots.Load()
+	if snap == nil {
+		return
+	}
+
+	if t, ok := snap.Snow.(*TiledDepthMap); ok {
+		t.Track(lat, lon, track)
+	}
+}
+
+func (g *gribService) GetSnowStats(lat, lon, radius float32) *AreaStats {
+	snap := g.snapshots.Load()
+	if snap == nil {
+		return nil
+	}
+
+	return StatsInRadius(snap.Snow, lat, lon, radius)
 }
 
 func (g *gribService) DownloadAndProcessGribFile(sys_time bool, month, day, hour int) (error, DepthMap, DepthMap) {
+	generation := g.snapshots.Generation()
 	file_override := 0
 
 	snow_csv_file := "snod.csv"
@@ -90,8 +182,13 @@ func (g *gribService) DownloadAndProcessGribFile(sys_time bool, month, day, hour
 		file_override++
 	}
 
+	// sea ice from the grib file or a csv file, none for a snow csv file only
+	ice_csv_file := os.Getenv("USE_ICEC_CSV")
+
 	var gribFilename string
 	var err error
+	source := snow_csv_file
+	var cycleTime time.Time
 
 	if file_override < 1 {
 		// download grib file
@@ -100,11 +197,35 @@ func (g *gribService) DownloadAndProcessGribFile(sys_time bool, month, day, hour
 			return err, nil, nil
 		}
 		// convert grib file to csv files
-		g.convertGribToCsv("snod.csv")
+		if err := g.convertGribToCsv(snow_csv_file); err != nil {
+			return err, nil, nil
+		}
+		if ice_csv_file == "" {
+			ice_csv_file = "icec.csv"
+			os.Remove(ice_csv_file) // not in every grib file, don't use the one of an earlier run
+			if err := g.gribToCsv(ice_csv_file, "ICEC"); err != nil {
+				g.Logger.Errorf("Error converting sea ice: %v", err)
+			}
+		}
+		source = gribFilename
+		cycleTime = g.gribCycleTime
 	}
 
-	gribSnow := &depthMap{name: "Snow", Logger: g.Logger}
-	gribSnow.LoadCsv(snow_csv_file)
+	storage, err := ParseStorage(os.Getenv("DEPTH_MAP_STORAGE"))
+	if err != nil {
+		g.Logger.Errorf("%v, using '%s'", err, storage.String())
+	}
+
+	gribSnow := newDepthMapStorage(g.Logger, "Snow", GlobalGrid, storage)
+	gribSnow.interp = g.interpolation("RAW_INTERPOLATION")
+	report, err := gribSnow.LoadCsv(snow_csv_file)
+	if err == nil {
+		err = report.Validate()
+	}
+	if err != nil {
+		g.Logger.Errorf("Loading snow depth failed: %v", err)
+		return err, nil, nil
+	}
 
 	// remove old grib files
 	err = g.removeOldGribFiles(gribFilename)
@@ -112,13 +233,318 @@ func (g *gribService) DownloadAndProcessGribFile(sys_time bool, month, day, hour
 		return err, nil, nil
 	}
 
-	coastalSnow := ElsaOnTheCoast(gribSnow, g.cs)
-	g.SnowDm = coastalSnow
-	g.ready = true
-	return nil, gribSnow, coastalSnow
+	ice := g.seaIce(ice_csv_file)
+	pipeline, err := g.pipeline(ice != nil)
+	if err != nil {
+		return err, nil, nil
+	}
+
+	coast_params, lake_params := g.coastParams()
+	pc := &PipelineContext{
+		Logger:    g.Logger,
+		Cs:        g.coastService(&coast_params),
+		Coast:     coast_params,
+		Lakes:     g.lakeService(&lake_params),
+		LakeCoast: lake_params,
+		Maps:      map[string]DepthMap{"raw": gribSnow},
+		Elevation: g.elevation(),
+		LandCover: g.landCover(),
+		SnowLine:  SnowLineModelFromEnv(g.Logger),
+		SeaIce:    ice,
+	}
+	if ice != nil {
+		// frozen sea is land for the coastal extension
+		if pc.Cs != nil {
+			pc.Cs = ice.Coast(g.Logger, pc.Cs)
+		}
+		if pc.Lakes != nil {
+			pc.Lakes = ice.Coast(g.Logger, pc.Lakes)
+		}
+	}
+	var snow DepthMap
+	snow_interp := g.interpolation("SNOW_INTERPOLATION")
+	if tile_size := envFloat(g.Logger, "SNOW_TILE_SIZE", 0); tile_size > 0 {
+		radius := envFloat(g.Logger, "SNOW_TILE_RADIUS", DefaultTileRadius) * 1000
+		tiled, err := NewTiledDepthMap(pc, pipeline, gribSnow, tile_size, radius,
+			envInt(g.Logger, "SNOW_TILE_CACHE", DefaultTileCache), snow_interp)
+		if err != nil {
+			g.Logger.Errorf("SNOW_TILE_SIZE: %v", err)
+			return err, nil, nil
+		}
+		g.Logger.Infof("Processing snow in %0.0f° tiles: %s", tile_size, pipeline.String())
+		snow = tiled
+	} else {
+		g.Logger.Infof("Processing snow: %s", pipeline.String())
+		dm, err := pipeline.Run(pc, gribSnow)
+		if err != nil {
+			g.Logger.Errorf("Processing snow failed: %v", err)
+			return err, nil, nil
+		}
+		// a copy, the pipeline may return gribSnow itself
+		snow = dm.withInterpolation(snow_interp)
+	}
+
+	snap := &SnowSnapshot{
+		Snow:      snow,
+		Raw:       gribSnow,
+		Source:    source,
+		CycleTime: cycleTime,
+		Pipeline:  pipeline.String(),
+		Coast:     pc.Coast,
+		Lakes:     lakesUsed(pc),
+		SeaIce:    ice,
+		Noise:     SnowNoiseFromEnv(g.Logger, pc.Elevation),
+		Created:   time.Now(),
+	}
+	if snap.Noise != nil {
+		g.Logger.Infof("Snow noise: %s", snap.Noise.String())
+	}
+	g.Logger.Infof("Snow provenance: %s", snap.Provenance())
+	if !g.snapshots.Publish(generation, snap) {
+		g.Logger.Infof("Snow data of '%s' is outdated, not published", source)
+	} else if !cycleTime.IsZero() {
+		g.addToHistory(cycleTime, snow)
+	}
+	return nil, gribSnow, snow
+}
+
+// snow history in the grib folder, nil if SNOW_HISTORY_DAYS is 0 or it can't be opened
+func (g *gribService) History() *SnowHistory {
+	g.histLock.Lock()
+	defer g.histLock.Unlock()
+
+	if envInt(g.Logger, "SNOW_HISTORY_DAYS", DefaultHistoryDays) <= 0 {
+		return nil
+	}
+	if g.hist == nil {
+		h, err := OpenSnowHistory(g.Logger, filepath.Join(g.gribFileFolder, "history"))
+		if err != nil {
+			g.Logger.Errorf("Can't open snow history: %v", err)
+			return nil
+		}
+		g.hist = h
+	}
+	return g.hist
+}
+
+// store the processed map and drop cycles older than SNOW_HISTORY_DAYS,
+// historic downloads beyond that are not stored
+func (g *gribService) addToHistory(cycleTime time.Time, snow DepthMap) {
+	h := g.History()
+	if h == nil {
+		return
+	}
+	// a tiled map is processed around the aircraft only, storing it would process the whole world
+	if _, ok := snow.(*TiledDepthMap); ok {
+		g.Logger.Infof("Snow history: tiled maps are not stored")
+		return
+	}
+
+	keep := time.Duration(envInt(g.Logger, "SNOW_HISTORY_DAYS", DefaultHistoryDays)) * 24 * time.Hour
+	now := time.Now()
+	if cycleTime.Before(now.Add(-keep)) {
+		return
+	}
+	if err := h.Add(cycleTime, snow); err != nil {
+		g.Logger.Errorf("Can't add to snow history: %v", err)
+		return
+	}
+	if n := h.Prune(now, keep); n > 0 {
+		g.Logger.Infof("Snow history: removed %d old cycles", n)
+	}
+}
+
+// processing stages from SNOW_PIPELINE, the default includes the DEM, land cover and sea ice
+// stages if configured
+func (g *gribService) pipeline(sea_ice bool) (*Pipeline, error) {
+	spec := os.Getenv("SNOW_PIPELINE")
+	if spec == "" {
+		spec = DefaultPipeline
+		if sea_ice {
+			spec = "seaice," + spec
+		}
+		if os.Getenv("SNOW_LANDCOVER") != "" {
+			spec = "landcover," + spec
+		}
+		if os.Getenv("SNOW_DEM") != "" {
+			spec = "dem," + spec
+		}
+	}
+
+	p, err := ParsePipeline(spec)
+	if err != nil {
+		g.Logger.Errorf("SNOW_PIPELINE: %v", err)
+		return nil, err
+	}
+	return p, nil
+}
+
+// lake shore parameters for the provenance, zero without a lake mask
+func lakesUsed(pc *PipelineContext) CoastParams {
+	if pc.Lakes == nil {
+		return CoastParams{}
+	}
+	return pc.LakeCoast
+}
+
+// coastal extension parameters from SNOW_COAST and for lake shores from SNOW_LAKE_COAST,
+// the default sets if they are invalid
+func (g *gribService) coastParams() (CoastParams, CoastParams) {
+	p, err := ParseCoastParams(os.Getenv("SNOW_COAST"))
+	if err != nil {
+		g.Logger.Errorf("SNOW_COAST: %v, using '%s'", err, p.Name)
+	}
+
+	lp := CoastParamSets["lakes"]
+	if spec := os.Getenv("SNOW_LAKE_COAST"); spec != "" {
+		if lp, err = ParseCoastParams(spec); err != nil {
+			lp = CoastParamSets["lakes"]
+			g.Logger.Errorf("SNOW_LAKE_COAST: %v, using '%s'", err, lp.Name)
+		}
+	}
+	return p, lp
+}
+
+// coast map for the offset in p, the Go coast map is classified again if the offset changed.
+// p.Offset is set to the offset actually used.
+func (g *gribService) coastService(p *CoastParams) CoastService {
+	g.csLock.Lock()
+	defer g.csLock.Unlock()
+
+	if g.cs == nil {
+		return nil
+	}
+
+	gcs, ok := g.cs.(*goCoastService)
+	if !ok {
+		if p.Offset != coast.DefaultOffset {
+			g.Logger.Errorf("SNOW_COAST: the offset of this coast map can't be changed, using %d", coast.DefaultOffset)
+			p.Offset = coast.DefaultOffset
+		}
+		return g.cs
+	}
+
+	if p.Offset != gcs.Offset() {
+		cs, err := gcs.withOffset(p.Offset)
+		if err != nil {
+			g.Logger.Errorf("SNOW_COAST: can't classify the coast with offset %d: %v", p.Offset, err)
+			p.Offset = gcs.Offset()
+			return g.cs
+		}
+		g.cs = cs
+	}
+	return g.cs
+}
+
+// lake shores from SNOW_LAKES, nil if not configured or not loadable.
+// p.Offset is set to the offset actually used.
+func (g *gribService) lakeService(p *CoastParams) CoastService {
+	path := os.Getenv("SNOW_LAKES")
+	if path == "" {
+		return nil
+	}
+
+	g.csLock.Lock()
+	defer g.csLock.Unlock()
+
+	if g.lakes == nil || g.lakes_path != path {
+		ls, err := newLakeService(g.Logger, path, p.Offset)
+		if err != nil {
+			g.Logger.Errorf("Can't load lake mask: %v", err)
+			return nil
+		}
+		g.lakes, g.lakes_path = ls, path
+	}
+
+	if p.Offset != g.lakes.Offset() {
+		ls, err := g.lakes.withOffset(p.Offset)
+		if err != nil {
+			g.Logger.Errorf("SNOW_LAKE_COAST: can't classify the lake shores with offset %d: %v", p.Offset, err)
+			p.Offset = g.lakes.Offset()
+			return g.lakes
+		}
+		g.lakes = ls
+	}
+	return g.lakes
+}
+
+// sea ice concentration from csv_name, nil if SNOW_SEA_ICE is 0 or there is none
+func (g *gribService) seaIce(csv_name string) *SeaIce {
+	if csv_name == "" {
+		return nil
+	}
+	s := SeaIceFromEnv(g.Logger)
+	if s == nil {
+		return nil
+	}
+	if err := s.Load(g.Logger, csv_name); err != nil {
+		g.Logger.Warningf("No sea ice: %v", err)
+		return nil
+	}
+	g.Logger.Infof("Sea ice: %s", s.String())
+	return s
+}
+
+// elevation map from SNOW_DEM, nil if not configured or not loadable
+func (g *gribService) elevation() *ElevationMap {
+	path := os.Getenv("SNOW_DEM")
+	if path == "" {
+		return nil
+	}
+
+	if g.elev == nil || g.elev_path != path {
+		elev, err := LoadElevationMap(g.Logger, path, GlobalGrid)
+		if err != nil {
+			g.Logger.Errorf("Can't load DEM: %v", err)
+			return nil
+		}
+		g.elev, g.elev_path = elev, path
+	}
+	return g.elev
 }
 
-func (g *gribService) getDownloadUrl(sys_time bool, timeUTC time.Time) (string, time.Time, int) {
+// land cover from SNOW_LANDCOVER with SNOW_LANDCOVER_RULES, nil if not configured or not loadable
+func (g *gribService) landCover() *LandCoverMap {
+	path := os.Getenv("SNOW_LANDCOVER")
+	if path == "" {
+		return nil
+	}
+
+	spec := os.Getenv("SNOW_LANDCOVER_RULES")
+	if spec == "" {
+		spec = DefaultLandCoverRules
+	}
+	rules, err := ParseLandCoverRules(spec)
+	if err != nil {
+		g.Logger.Errorf("SNOW_LANDCOVER_RULES: %v", err)
+		return nil
+	}
+
+	if g.lc == nil || g.lc_path != path {
+		lc, err := LoadLandCoverMap(g.Logger, path, rules)
+		if err != nil {
+			g.Logger.Errorf("Can't load land cover: %v", err)
+			return nil
+		}
+		g.lc, g.lc_path = lc, path
+	}
+	// the raster is shared, the rules may have changed
+	lc := *g.lc
+	lc.Rules = rules
+	return &lc
+}
+
+// kernel as configured by the environment variable
+func (g *gribService) interpolation(env string) Interpolation {
+	ip, err := ParseInterpolation(os.Getenv(env))
+	if err != nil {
+		g.Logger.Errorf("%s: %v, using '%s'", env, err, ip.String())
+	}
+	return ip
+}
+
+// -> url, cycle time, cycle, forecast hour
+func (g *gribService) getDownloadUrl(sys_time bool, timeUTC time.Time) (string, time.Time, int, int) {
 	g.Logger.Infof("timeUTC:  %s", timeUTC.String())
 	ctimeUTC := timeUTC.Add(-4*time.Hour - 25*time.Minute) // Adjusted time considering publish delay
 	g.Logger.Infof("ctimeUTC: %s", ctimeUTC.String())
@@ -141,21 +567,47 @@ func (g *gribService) getDownloadUrl(sys_time bool, timeUTC time.Time) (string,
 	if sys_time {
 		filename := fmt.Sprintf("gfs.t%02dz.pgrb2.0p25.f0%02d", cycle, forecast)
 		g.Logger.Infof("NOAA Filename: %s, %d, %d", filename, cycle, forecast)
-		url := fmt.Sprintf("https://nomads.ncep.noaa.gov/cgi-bin/filter_gfs_0p25.pl?dir=%%2Fgfs.%s%%2F%02d%%2Fatmos&file=%s&var_SNOD=on&all_lev=on", cycleDate, cycle, filename)
-		return url, ctimeUTC, cycle
+		url := fmt.Sprintf("https://nomads.ncep.noaa.gov/cgi-bin/filter_gfs_0p25.pl?dir=%%2Fgfs.%s%%2F%02d%%2Fatmos&file=%s&var_ICEC=on&var_SNOD=on&all_lev=on", cycleDate, cycle, filename)
+		return url, ctimeUTC, cycle, forecast
 	} else {
+		if m, _ := g.GetArchiveManifest(); m != nil {
+			if e := m.Nearest(timeUTC); e != nil {
+				gap := e.ValidTime().Sub(timeUTC)
+				if gap < 0 {
+					gap = -gap
+				}
+				if gap > archiveMaxGap {
+					g.Logger.Warningf("Nearest archived dataset is %s off the requested time", gap.String())
+				}
+				url := m.EntryUrl(e)
+				g.Logger.Infof("ARCHIVE Filename: %s, %d, %d", e.File, e.Cycle, e.Forecast)
+				return url, e.CycleTime(), e.Cycle, e.Forecast
+			}
+			g.Logger.Warning("Archive manifest is empty, guessing the file name")
+		}
+
 		forecast = 6 // TODO: for now
 		filename := fmt.Sprintf("gfs.0p25.%s%02d.f0%02d.grib2", cycleDate, cycle, forecast)
 		g.Logger.Infof("GITHUB Filename: %s, %d, %d", filename, cycle, forecast)
 		url := fmt.Sprintf("https://github.com/xairline/weather-data/releases/download/daily/%s", filename)
-		return url, ctimeUTC, cycle
+		return url, ctimeUTC, cycle, forecast
 	}
 
 	//return fmt.Sprintf("https://nomads.ncep.noaa.gov/pub/data/nccf/com/gfs/prod/gfs.%s/%02d/atmos/%s", cycleDate, cycle, filename)
 }
 
-func (g *gribService) convertGribToCsv(snow_csv_name string) {
-	g.Logger.Infof("Pre-processing GRIB file to CSV: '%s'", snow_csv_name)
+func (g *gribService) convertGribToCsv(snow_csv_name string) error {
+	os.Remove(snow_csv_name) // don't load the one of an earlier run if wgrib2 fails
+	if err := g.gribToCsv(snow_csv_name, "SNOD"); err != nil {
+		g.Logger.Errorf("Error converting grib file: %v", err)
+		return err
+	}
+	return nil
+}
+
+// field of the grib file on the 0.1° grid
+func (g *gribService) gribToCsv(csv_name, field string) error {
+	g.Logger.Infof("Pre-processing GRIB file to CSV: '%s'", csv_name)
 	//get current OS
 	myOs := runtime.GOOS
 	var executablePath string
@@ -171,13 +623,13 @@ func (g *gribService) convertGribToCsv(snow_csv_name string) {
 	// export grib file to csv
 	// 0:3600:0.1 means scan longitude from 0, 3600 steps with step 0.1 degree
 	// -90:1800:0.1 means scan latitude from -90, 1800 steps with step 0.1 degree
-	cmd := exec.Command(executablePath, "-s", "-lola", "0:3600:0.1", "-90:1800:0.1", snow_csv_name, "spread", g.gribFilePath, "-match_fs", "SNOD")
-	err := g.exec(cmd)
-	if err != nil {
-		g.Logger.Errorf("Error converting grib file: %v", err)
+	cmd := exec.Command(executablePath, "-s", "-lola", "0:3600:0.1", "-90:1800:0.1", csv_name, "spread", g.gribFilePath, "-match_fs", field)
+	if err := g.exec(cmd); err != nil {
+		return err
 	}
 
 	g.Logger.Info("Pre-processing GRIB file to CSV: Done")
+	return nil
 }
 
 // day, month, hour are in the local TZ
@@ -213,13 +665,14 @@ func (g *gribService) downloadGribFile(sys_time bool, day, month, hour int) (str
 		timeUTC = time.Date(year, time.Month(month), day, hour, 0, 0, 0, loc).UTC()
 	}
 
-	url, ctimeUTC, cycle := g.getDownloadUrl(sys_time, timeUTC)
+	url, ctimeUTC, cycle, forecast := g.getDownloadUrl(sys_time, timeUTC)
 	g.Logger.Infof("Downloading GRIB file from %s", url)
 	// Get today's date in yyyy-mm-dd format
 	today := ctimeUTC.Format("2006-01-02")
-	// Create the filename with today's date
-	filename := today + "_" + fmt.Sprintf("%d", cycle) + "_noaa.grib2"
+	// Create the filename with today's date, cycle and forecast hour
+	filename := fmt.Sprintf("%s_%d_f%03d_noaa.grib2", today, cycle, forecast)
 	g.gribFilePath = filepath.Join(g.gribFileFolder, filename)
+	g.gribCycleTime = time.Date(ctimeUTC.Year(), ctimeUTC.Month(), ctimeUTC.Day(), cycle, 0, 0, 0, time.UTC)
 	g.Logger.Infof("GRIB file path: %s", g.gribFilePath)
 
 	// if file does not exist, download
@@ -48,7 +48,6 @@ func (m *MockLogger) Errorf(format string, args ...interface{}) {
 
 var (
 	service 	GribService
-	p2x			Phys2XPlane
 	mockLogger	*MockLogger
 )
 
@@ -59,8 +58,8 @@ func TestDownloadGribFile(t *testing.T) {
 	mockLogger.On("Infof", mock.Anything, mock.Anything).Return()
 	mockLogger.On("Errorf", mock.Anything, mock.Anything).Return()
 
-	service = NewGribService(mockLogger, ".", "bin", NewCoastService(mockLogger, ".."))
-	p2x = NewPhys2XPlane(mockLogger)
+	cs, _ := NewCoastService(mockLogger, "..") // nil without the water mask
+	service = NewGribService(mockLogger, ".", "bin", cs)
 
 	_, _, _ = service.DownloadAndProcessGribFile(true, 0, 0, 0)
 	mockLogger.AssertCalled(t, "Infof", "Downloading GRIB file from %s", mock.Anything)
@@ -70,14 +69,14 @@ func TestWrap(t *testing.T) {
 
 	// call at a few locations that wrap indices and are prone to range violations
 	// just check whether it bombs
-	p2x.SnowDepthToXplaneSnowNow(service.GetSnowDepth(51, 0.1))
-	p2x.SnowDepthToXplaneSnowNow(service.GetSnowDepth(51, -0.1))
-	p2x.SnowDepthToXplaneSnowNow(service.GetSnowDepth(51, 0.1))
-	p2x.SnowDepthToXplaneSnowNow(service.GetSnowDepth(51, -0.1))
-	p2x.SnowDepthToXplaneSnowNow(service.GetSnowDepth(-50, 180))
-	p2x.SnowDepthToXplaneSnowNow(service.GetSnowDepth(-50, -180))
-	p2x.SnowDepthToXplaneSnowNow(service.GetSnowDepth(-50, 179.9))
-	p2x.SnowDepthToXplaneSnowNow(service.GetSnowDepth(-50, -179.9))
-	p2x.SnowDepthToXplaneSnowNow(service.GetSnowDepth(90, 179.9))
-	p2x.SnowDepthToXplaneSnowNow(service.GetSnowDepth(-90, -179.9))
+	SnowDepthToXplaneSnowNow(service.GetSnowDepth(51, 0.1))
+	SnowDepthToXplaneSnowNow(service.GetSnowDepth(51, -0.1))
+	SnowDepthToXplaneSnowNow(service.GetSnowDepth(51, 0.1))
+	SnowDepthToXplaneSnowNow(service.GetSnowDepth(51, -0.1))
+	SnowDepthToXplaneSnowNow(service.GetSnowDepth(-50, 180))
+	SnowDepthToXplaneSnowNow(service.GetSnowDepth(-50, -180))
+	SnowDepthToXplaneSnowNow(service.GetSnowDepth(-50, 179.9))
+	SnowDepthToXplaneSnowNow(service.GetSnowDepth(-50, -179.9))
+	SnowDepthToXplaneSnowNow(service.GetSnowDepth(90, 179.9))
+	SnowDepthToXplaneSnowNow(service.GetSnowDepth(-90, -179.9))
 }
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"fmt"
+	"math"
+)
+
+// Geometry of a regular lon/lat grid. Values live on the grid points, point (0, 0) is at (Lon0, Lat0).
+// Longitudes are taken modulo 360 so a grid may start at 0 or at -180 or cross the antimeridian.
+type Grid struct {
+	Lon0, Lat0 float32 // position of index (0, 0)
+	DLon, DLat float32 // step sizes in °
+	NLon, NLat int
+	WrapLon    bool    // grid spans the full circle and wraps around in lon
+	NoData     float32 // returned for points outside of a regional grid
+}
+
+// the world in 0.1° resolution as delivered by wgrib2
+var GlobalGrid = Grid{Lon0: 0, Lat0: -90, DLon: 0.1, DLat: 0.1, NLon: n_iLon, NLat: n_iLat, WrapLon: true}
+
+// a regional, non wrapping grid
+func NewGrid(lon0, lat0, dLon, dLat float32, nLon, nLat int) Grid {
+	return Grid{Lon0: lon0, Lat0: lat0, DLon: dLon, DLat: dLat, NLon: nLon, NLat: nLat}
+}
+
+// a world grid with given step sizes
+func NewGlobalGrid(dLon, dLat float32) Grid {
+	nLon := int(math.Round(360 / float64(dLon)))
+	nLat := int(math.Round(180/float64(dLat))) + 1
+	return Grid{Lon0: 0, Lat0: -90, DLon: dLon, DLat: dLat, NLon: nLon, NLat: nLat, WrapLon: true}
+}
+
+func (g *Grid) Check() error {
+	if g.DLon <= 0 || g.DLat <= 0 || g.NLon <= 0 || g.NLat <= 0 {
+		return fmt.Errorf("invalid grid size %dx%d, step %f/%f", g.NLon, g.NLat, g.DLon, g.DLat)
+	}
+	if g.WrapLon && math.Abs(float64(g.DLon)*float64(g.NLon)-360) > 1e-3 {
+		return fmt.Errorf("wrapping grid does not span 360°: %d * %f", g.NLon, g.DLon)
+	}
+	return nil
+}
+
+func (g *Grid) Size() int {
+	return g.NLon * g.NLat
+}
+
+// true if the grid covers both poles, then lat indices are clamped instead of being cut off
+func (g *Grid) polar() bool {
+	return g.Lat0 <= -90 && g.Lat0+float32(g.NLat-1)*g.DLat >= 90
+}
+
+// fractional index of (lon, lat)
+func (g *Grid) FIdx(lon, lat float32) (float32, float32) {
+	dlon := math.Mod(float64(lon-g.Lon0), 360)
+	if dlon < 0 {
+		dlon += 360
+	}
+	return float32(dlon) / g.DLon, (lat - g.Lat0) / g.DLat
+}
+
+// nearest grid point of (lon, lat), ok is false if it is not on the grid
+func (g *Grid) Idx(lon, lat float32) (iLon, iLat int, ok bool) {
+	fLon, fLat := g.FIdx(lon, lat)
+	iLon = int(math.Round(float64(fLon)))
+	iLat = int(math.Round(float64(fLat)))
+	if g.WrapLon && iLon == g.NLon {
+		iLon = 0
+	}
+	ok = 0 <= iLon && iLon < g.NLon && 0 <= iLat && iLat < g.NLat
+	return
+}
+
+func (g *Grid) LonLat(iLon, iLat int) (float32, float32) {
+	return g.Lon0 + float32(iLon)*g.DLon, g.Lat0 + float32(iLat)*g.DLat
+}
+
+// map an index to the grid, lon wraps around on wrapping grids and lat is clamped on polar grids
+func (g *Grid) wrap(iLon, iLat int) (int, int, bool) {
+	if g.WrapLon {
+		iLon %= g.NLon
+		if iLon < 0 {
+			iLon += g.NLon
+		}
+	} else if iLon < 0 || iLon >= g.NLon {
+		return iLon, iLat, false
+	}
+
+	// for lat we just confine, doesn't make a difference anyway
+	if iLat < 0 || iLat >= g.NLat {
+		if !g.polar() {
+			return iLon, iLat, false
+		}
+		if iLat < 0 {
+			iLat = 0
+		} else {
+			iLat = g.NLat - 1
+		}
+	}
+
+	return iLon, iLat, true
+}
+
+// like wrap but confine to the grid's border
+func (g *Grid) clamp(iLon, iLat int) (int, int) {
+	if g.WrapLon {
+		iLon %= g.NLon
+		if iLon < 0 {
+			iLon += g.NLon
+		}
+	} else if iLon < 0 {
+		iLon = 0
+	} else if iLon >= g.NLon {
+		iLon = g.NLon - 1
+	}
+
+	if iLat < 0 {
+		iLat = 0
+	} else if iLat >= g.NLat {
+		iLat = g.NLat - 1
+	}
+	return iLon, iLat
+}
+
+// true if (fLon, fLat) lies within the area covered by the grid
+func (g *Grid) inside(fLon, fLat float32) bool {
+	if !g.WrapLon && (fLon < 0 || fLon > float32(g.NLon-1)) {
+		return false
+	}
+	if !g.polar() && (fLat < 0 || fLat > float32(g.NLat-1)) {
+		return false
+	}
+	return true
+}
+
+// index of g's point (0, 0) on o if every point of g is a point of o (same steps, aligned origin)
+func (g *Grid) offsetIn(o *Grid) (di, dj int, ok bool) {
+	if g.DLon != o.DLon || g.DLat != o.DLat || (g.WrapLon && !g.Equal(o)) {
+		return 0, 0, false
+	}
+
+	fi, fj := o.FIdx(g.Lon0, g.Lat0)
+	di, dj = int(math.Round(float64(fi))), int(math.Round(float64(fj)))
+	if math.Abs(float64(fi)-float64(di)) > 1e-2 || math.Abs(float64(fj)-float64(dj)) > 1e-2 {
+		return 0, 0, false
+	}
+	if (!o.WrapLon && di+g.NLon > o.NLon) || dj < 0 || dj+g.NLat > o.NLat {
+		return 0, 0, false
+	}
+	return di, dj, true
+}
+
+// same geometry (ignoring NoData)
+func (g *Grid) Equal(o *Grid) bool {
+	return g.Lon0 == o.Lon0 && g.Lat0 == o.Lat0 && g.DLon == o.DLon && g.DLat == o.DLat &&
+		g.NLon == o.NLon && g.NLat == o.NLat && g.WrapLon == o.WrapLon
+}
+
+func (g Grid) String() string {
+	return fmt.Sprintf("%dx%d @ (%0.3f, %0.3f) step %0.3f/%0.3f wrap: %v", g.NLon, g.NLat,
+		g.Lon0, g.Lat0, g.DLon, g.DLat, g.WrapLon)
+}
@@ -0,0 +1,409 @@
+package services
+
+import (
+	"bytes"
+	"compress/flate"
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"github.com/xairline/xa-snow/utils/logger"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Time series of processed snow maps, one file per model cycle. Depths are stored in mm like
+// the quantized store, in bands of lon columns that are deflated separately so a point query
+// only reads a small part of a file. A global 0.1° map takes 1-3 MB.
+const (
+	DefaultHistoryDays = 10
+	historyBand        = 64 // lon columns per band
+	historyCacheBands  = 64 // ~15 MB on the global grid
+	historyTimeFormat  = "2006010215"
+)
+
+var historyMagic = [8]byte{'X', 'A', 'S', 'N', 'O', 'W', 'H', '1'}
+
+type historyHeader struct {
+	Magic                  [8]byte
+	Cycle                  int64 // unix time
+	Lon0, Lat0, DLon, DLat float32
+	NLon, NLat             int32
+	WrapLon                uint8
+	_                      [3]byte
+	NoData                 float32
+	Band                   int32 // lon columns per band
+}
+
+type historyFile struct {
+	path    string
+	cycle   time.Time
+	grid    Grid
+	band    int
+	offsets []int64 // of the bands and the end
+}
+
+type historyBandKey struct {
+	cycle int64
+	band  int
+}
+
+type historyBandEntry struct {
+	key historyBandKey
+	val []uint16 // [iLon * NLat + iLat] relative to the band's first column
+}
+
+// one value of a point's time series
+type HistoryPoint struct {
+	Cycle time.Time
+	Depth float32
+}
+
+type SnowHistory struct {
+	Logger logger.Logger
+	dir    string
+
+	lock  sync.Mutex
+	files []*historyFile // sorted by cycle
+	lru   *list.List     // of *historyBandEntry, most recent first
+	bands map[historyBandKey]*list.Element
+}
+
+func historyFileName(cycle time.Time) string {
+	return "snow_" + cycle.UTC().Format(historyTimeFormat) + ".xsh"
+}
+
+// the directory is created if needed, unreadable files in it are skipped
+func OpenSnowHistory(logger logger.Logger, dir string) (*SnowHistory, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	h := &SnowHistory{Logger: logger, dir: dir, lru: list.New(), bands: make(map[historyBandKey]*list.Element)}
+	names, err := filepath.Glob(filepath.Join(dir, "snow_*.xsh"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		f, err := readHistoryIndex(name)
+		if err != nil {
+			logger.Errorf("Snow history: %v", err)
+			continue
+		}
+		h.files = append(h.files, f)
+	}
+	sort.Slice(h.files, func(i, j int) bool { return h.files[i].cycle.Before(h.files[j].cycle) })
+	logger.Infof("Snow history '%s': %d cycles", dir, len(h.files))
+	return h, nil
+}
+
+func readHistoryIndex(path string) (*historyFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var hdr historyHeader
+	if err := binary.Read(file, binary.LittleEndian, &hdr); err != nil {
+		return nil, fmt.Errorf("'%s': %w", path, err)
+	}
+	if hdr.Magic != historyMagic {
+		return nil, fmt.Errorf("'%s': not a snow history file", path)
+	}
+
+	f := &historyFile{
+		path:  path,
+		cycle: time.Unix(hdr.Cycle, 0).UTC(),
+		grid: Grid{Lon0: hdr.Lon0, Lat0: hdr.Lat0, DLon: hdr.DLon, DLat: hdr.DLat,
+			NLon: int(hdr.NLon), NLat: int(hdr.NLat), WrapLon: hdr.WrapLon != 0, NoData: hdr.NoData},
+		band: int(hdr.Band),
+	}
+	if err := f.grid.Check(); err != nil || f.band <= 0 {
+		return nil, fmt.Errorf("'%s': invalid header", path)
+	}
+
+	n_bands := (f.grid.NLon + f.band - 1) / f.band
+	f.offsets = make([]int64, n_bands+1)
+	if err := binary.Read(file, binary.LittleEndian, f.offsets); err != nil {
+		return nil, fmt.Errorf("'%s': %w", path, err)
+	}
+	return f, nil
+}
+
+// store the map of a cycle, an existing entry for the cycle is replaced
+func (h *SnowHistory) Add(cycle time.Time, dm DepthMap) error {
+	cycle = cycle.UTC().Truncate(time.Hour)
+	g := *dm.Grid()
+	q := &quantizedStore{nLat: g.NLat, noData: g.NoData}
+
+	hdr := historyHeader{
+		Magic: historyMagic, Cycle: cycle.Unix(),
+		Lon0: g.Lon0, Lat0: g.Lat0, DLon: g.DLon, DLat: g.DLat,
+		NLon: int32(g.NLon), NLat: int32(g.NLat), NoData: g.NoData, Band: historyBand,
+	}
+	if g.WrapLon {
+		hdr.WrapLon = 1
+	}
+
+	// deflate the bands, then write header, index and bands
+	n_bands := (g.NLon + historyBand - 1) / historyBand
+	offsets := make([]int64, n_bands+1)
+	offsets[0] = int64(binary.Size(hdr) + 8*len(offsets))
+	var data bytes.Buffer
+	raw := make([]byte, 2*historyBand*g.NLat)
+	zw, _ := flate.NewWriter(&data, flate.BestSpeed)
+	for b := 0; b < n_bands; b++ {
+		i0, i1 := b*historyBand, min(g.NLon, (b+1)*historyBand)
+		k := 0
+		for i := i0; i < i1; i++ {
+			for j := 0; j < g.NLat; j++ {
+				binary.LittleEndian.PutUint16(raw[k:], q.quantize(dm.GetIdx(i, j)))
+				k += 2
+			}
+		}
+		zw.Reset(&data)
+		zw.Write(raw[:k])
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		offsets[b+1] = offsets[0] + int64(data.Len())
+	}
+
+	path := filepath.Join(h.dir, historyFileName(cycle))
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(file, binary.LittleEndian, &hdr)
+	if err == nil {
+		err = binary.Write(file, binary.LittleEndian, offsets)
+	}
+	if err == nil {
+		_, err = file.Write(data.Bytes())
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	f := &historyFile{path: path, cycle: cycle, grid: g, band: historyBand, offsets: offsets}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.dropCycle(cycle)
+	i := sort.Search(len(h.files), func(i int) bool { return !h.files[i].cycle.Before(cycle) })
+	h.files = append(h.files, nil)
+	copy(h.files[i+1:], h.files[i:])
+	h.files[i] = f
+	h.Logger.Infof("Snow history: added cycle %s, %d kB", cycle.Format(historyTimeFormat), offsets[n_bands]/1024)
+	return nil
+}
+
+// remove the entry of a cycle and its cached bands, lock must be held
+func (h *SnowHistory) dropCycle(cycle time.Time) {
+	for i, f := range h.files {
+		if f.cycle.Equal(cycle) {
+			h.files = append(h.files[:i], h.files[i+1:]...)
+			break
+		}
+	}
+	for k, e := range h.bands {
+		if k.cycle == cycle.Unix() {
+			h.lru.Remove(e)
+			delete(h.bands, k)
+		}
+	}
+}
+
+// delete cycles older than keep before now, -> number of cycles removed
+func (h *SnowHistory) Prune(now time.Time, keep time.Duration) int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	limit := now.Add(-keep)
+	n := 0
+	for len(h.files) > 0 && h.files[0].cycle.Before(limit) {
+		f := h.files[0]
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			h.Logger.Errorf("Snow history: %v", err)
+			break
+		}
+		h.dropCycle(f.cycle)
+		n++
+	}
+	return n
+}
+
+func (h *SnowHistory) Cycles() []time.Time {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	c := make([]time.Time, len(h.files))
+	for i, f := range h.files {
+		c[i] = f.cycle
+	}
+	return c
+}
+
+// index of the latest cycle at or before t, -1 if none, lock must be held
+func (h *SnowHistory) before(t time.Time) int {
+	return sort.Search(len(h.files), func(i int) bool { return h.files[i].cycle.After(t) }) - 1
+}
+
+// band of a file, cached, lock must be held
+func (h *SnowHistory) band(f *historyFile, b int) ([]uint16, error) {
+	key := historyBandKey{f.cycle.Unix(), b}
+	if e, ok := h.bands[key]; ok {
+		h.lru.MoveToFront(e)
+		return e.Value.(*historyBandEntry).val, nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	n := (min(f.grid.NLon, (b+1)*f.band) - b*f.band) * f.grid.NLat
+	zr := flate.NewReader(io.NewSectionReader(file, f.offsets[b], f.offsets[b+1]-f.offsets[b]))
+	raw := make([]byte, 2*n)
+	if _, err := io.ReadFull(zr, raw); err != nil {
+		return nil, fmt.Errorf("'%s': band %d: %w", f.path, b, err)
+	}
+	val := make([]uint16, n)
+	for i := range val {
+		val[i] = binary.LittleEndian.Uint16(raw[2*i:])
+	}
+
+	h.bands[key] = h.lru.PushFront(&historyBandEntry{key, val})
+	for h.lru.Len() > historyCacheBands {
+		e := h.lru.Back()
+		delete(h.bands, e.Value.(*historyBandEntry).key)
+		h.lru.Remove(e)
+	}
+	return val, nil
+}
+
+// depth at the grid point nearest to (lon, lat), lock must be held
+func (h *SnowHistory) depth(f *historyFile, lon, lat float32) (float32, error) {
+	i, j, ok := f.grid.Idx(lon, lat)
+	if !ok {
+		return 0, fmt.Errorf("%0.2f/%0.2f is not on the grid of cycle %s", lat, lon, f.cycle.Format(historyTimeFormat))
+	}
+
+	b := i / f.band
+	val, err := h.band(f, b)
+	if err != nil {
+		return 0, err
+	}
+	q := &quantizedStore{nLat: f.grid.NLat, noData: f.grid.NoData, val: val}
+	return q.get(i-b*f.band, j), nil
+}
+
+// depth of the latest cycle at or before t
+func (h *SnowHistory) DepthAt(lon, lat float32, t time.Time) (float32, time.Time, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	i := h.before(t)
+	if i < 0 {
+		return 0, time.Time{}, fmt.Errorf("no snow history before %s", t.UTC().Format(time.RFC3339))
+	}
+	sd, err := h.depth(h.files[i], lon, lat)
+	return sd, h.files[i].cycle, err
+}
+
+// change of the depth in the period d before t, > 0 for fresh snow
+func (h *SnowHistory) Change(lon, lat float32, t time.Time, d time.Duration) (float32, error) {
+	now, _, err := h.DepthAt(lon, lat, t)
+	if err != nil {
+		return 0, err
+	}
+	then, _, err := h.DepthAt(lon, lat, t.Add(-d))
+	if err != nil {
+		return 0, err
+	}
+	return now - then, nil
+}
+
+// Start of the snow cover at t: the first cycle of the uninterrupted run of covered cycles up to
+// the latest one at or before t. ok is false if there is no snow at t. If the history starts
+// with snow the first cycle stored is returned.
+func (h *SnowHistory) FirstSnow(lon, lat float32, t time.Time) (first time.Time, ok bool, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for i := h.before(t); i >= 0; i-- {
+		sd, err := h.depth(h.files[i], lon, lat)
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if sd < snowCoveredDepth {
+			break
+		}
+		first, ok = h.files[i].cycle, true
+	}
+	return first, ok, nil
+}
+
+// depths of all cycles in [from, to]
+func (h *SnowHistory) Series(lon, lat float32, from, to time.Time) ([]HistoryPoint, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	var s []HistoryPoint
+	for _, f := range h.files {
+		if f.cycle.Before(from) || f.cycle.After(to) {
+			continue
+		}
+		sd, err := h.depth(f, lon, lat)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, HistoryPoint{f.cycle, sd})
+	}
+	return s, nil
+}
+
+// complete map of the latest cycle at or before t, e.g. for a replay
+func (h *SnowHistory) Load(t time.Time) (DepthMap, time.Time, error) {
+	h.lock.Lock()
+	i := h.before(t)
+	if i < 0 {
+		h.lock.Unlock()
+		return nil, time.Time{}, fmt.Errorf("no snow history before %s", t.UTC().Format(time.RFC3339))
+	}
+	f := h.files[i]
+	h.lock.Unlock()
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer file.Close()
+
+	dm := newDepthMapStorage(h.Logger, "Snow "+f.cycle.Format(historyTimeFormat), f.grid, StorageQuantized)
+	q := dm.val.(*quantizedStore)
+	raw := make([]byte, 2*f.band*f.grid.NLat)
+	for b := 0; b+1 < len(f.offsets); b++ {
+		n := (min(f.grid.NLon, (b+1)*f.band) - b*f.band) * f.grid.NLat
+		zr := flate.NewReader(io.NewSectionReader(file, f.offsets[b], f.offsets[b+1]-f.offsets[b]))
+		if _, err := io.ReadFull(zr, raw[:2*n]); err != nil {
+			return nil, time.Time{}, fmt.Errorf("'%s': band %d: %w", f.path, b, err)
+		}
+		base := b * f.band * f.grid.NLat
+		for k := 0; k < n; k++ {
+			q.val[base+k] = binary.LittleEndian.Uint16(raw[2*k:])
+		}
+	}
+	return dm, f.cycle, nil
+}
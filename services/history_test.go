@@ -0,0 +1,132 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+// 1° world with sd everywhere, 0.3 m at 10/50
+func historyMap(sd float32) *depthMap {
+	m := newDepthMap(newMockLogger(), "Snow", NewGlobalGrid(1, 1))
+	g := m.grid
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			m.set(i, j, sd)
+		}
+	}
+	m.set(10, 140, 0.3)
+	return m
+}
+
+func TestSnowHistory(t *testing.T) {
+	dir := t.TempDir()
+	h, err := OpenSnowHistory(newMockLogger(), dir)
+	assert.NoError(t, err)
+
+	t0 := time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)
+	for k, sd := range []float32{0, 0.05, 0.12, 0.2} {
+		assert.NoError(t, h.Add(t0.Add(time.Duration(k)*6*time.Hour), historyMap(sd)))
+	}
+	// replaced
+	assert.NoError(t, h.Add(t0.Add(18*time.Hour), historyMap(0.25)))
+	assert.Equal(t, 4, len(h.Cycles()))
+
+	sd, cycle, err := h.DepthAt(45, 45, t0.Add(14*time.Hour))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.12, sd, 1e-6)
+	assert.Equal(t, t0.Add(12*time.Hour), cycle)
+
+	sd, _, err = h.DepthAt(10, 50, t0.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.3, sd, 1e-6)
+
+	_, _, err = h.DepthAt(45, 45, t0.Add(-time.Hour))
+	assert.Error(t, err)
+
+	d, err := h.Change(45, 45, t0.Add(20*time.Hour), 18*time.Hour)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.25, d, 1e-6)
+
+	first, ok, err := h.FirstSnow(45, 45, t0.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, t0.Add(6*time.Hour), first)
+
+	// snow all the time
+	first, ok, err = h.FirstSnow(10, 50, t0.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, t0, first)
+
+	_, ok, err = h.FirstSnow(45, 45, t0.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	s, err := h.Series(45, 45, t0.Add(time.Hour), t0.Add(12*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(s))
+	assert.Equal(t, t0.Add(6*time.Hour), s[0].Cycle)
+	assert.InDelta(t, 0.05, s[0].Depth, 1e-6)
+
+	dm, cycle, err := h.Load(t0.Add(100 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, t0.Add(18*time.Hour), cycle)
+	assert.Equal(t, *historyMap(0).Grid(), *dm.Grid())
+	assert.InDelta(t, 0.25, dm.GetIdx(200, 30), 1e-6)
+	assert.InDelta(t, 0.3, dm.GetIdx(10, 140), 1e-6)
+
+	// persisted
+	h, err = OpenSnowHistory(newMockLogger(), dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(h.Cycles()))
+	sd, _, err = h.DepthAt(45, 45, t0.Add(7*time.Hour))
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.05, sd, 1e-6)
+
+	assert.Equal(t, 2, h.Prune(t0.Add(3*24*time.Hour), 60*time.Hour))
+	assert.Equal(t, []time.Time{t0.Add(12 * time.Hour), t0.Add(18 * time.Hour)}, h.Cycles())
+	files, _ := os.ReadDir(dir)
+	assert.Equal(t, 2, len(files))
+}
+
+func TestSnowHistorySize(t *testing.T) {
+	h, err := OpenSnowHistory(newMockLogger(), t.TempDir())
+	assert.NoError(t, err)
+
+	m := newDepthMap(newMockLogger(), "Snow", GlobalGrid)
+	for i := 0; i < 600; i++ {
+		for j := 1400; j < 1600; j++ {
+			m.set(i, j, float32(i+j)*0.0001)
+		}
+	}
+	cycle := time.Date(2026, 1, 10, 6, 0, 0, 0, time.UTC)
+	assert.NoError(t, h.Add(cycle, m))
+
+	fi, err := os.Stat(h.files[0].path)
+	assert.NoError(t, err)
+	assert.Less(t, fi.Size(), int64(2*1024*1024))
+
+	sd, _, err := h.DepthAt(30, 70, cycle)
+	assert.NoError(t, err)
+	assert.InDelta(t, m.Get(30, 70), sd, 1e-3)
+}
+
+func TestHistorySkipsTiles(t *testing.T) {
+	g := &gribService{Logger: newMockLogger(), gribFileFolder: t.TempDir()}
+	raw := stripedSnow()
+	pc := &PipelineContext{Logger: newMockLogger(), Cs: stubCoast{}, Maps: map[string]DepthMap{"raw": raw}}
+	p, _ := ParsePipeline("coast")
+	tiled, err := NewTiledDepthMap(pc, p, raw, 10, 100000, 4, raw.interp)
+	assert.NoError(t, err)
+
+	cycle := time.Now().UTC().Truncate(6 * time.Hour)
+	g.addToHistory(cycle, tiled)
+	assert.Equal(t, 0, len(g.History().Cycles()))
+	_, processed := tiled.Stats()
+	assert.Equal(t, 0, processed)
+
+	g.addToHistory(cycle, raw)
+	assert.Equal(t, 1, len(g.History().Cycles()))
+}
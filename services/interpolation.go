@@ -0,0 +1,94 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// interpolation kernel used by DepthMap.Get
+type Interpolation int
+
+const (
+	InterpBilinear   Interpolation = iota
+	InterpNearest                  // value of the closest grid point
+	InterpBicubic                  // Catmull-Rom, confined to the range of the surrounding grid points
+	InterpSmoothStep               // bilinear with smoothstep weights, no kinks at the grid lines
+)
+
+func ParseInterpolation(s string) (Interpolation, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "bilinear":
+		return InterpBilinear, nil
+	case "nearest":
+		return InterpNearest, nil
+	case "bicubic", "catmull-rom":
+		return InterpBicubic, nil
+	case "smoothstep":
+		return InterpSmoothStep, nil
+	}
+	return InterpBilinear, fmt.Errorf("unknown interpolation '%s'", s)
+}
+
+func (ip Interpolation) String() string {
+	return [...]string{"bilinear", "nearest", "bicubic", "smoothstep"}[ip]
+}
+
+// (iLon, iLat) is the lower left corner of the tile, (s, t) in [0,1] the position within
+func (m *depthMap) interpolate(iLon, iLat int, s, t float32) float32 {
+	switch m.interp {
+	case InterpNearest:
+		if s >= 0.5 {
+			iLon++
+		}
+		if t >= 0.5 {
+			iLat++
+		}
+		return m.getClamped(iLon, iLat)
+
+	case InterpBicubic:
+		return m.bicubic(iLon, iLat, s, t)
+
+	case InterpSmoothStep:
+		s = s * s * (3 - 2*s)
+		t = t * t * (3 - 2*t)
+	}
+
+	v00 := m.getClamped(iLon, iLat)
+	v10 := m.getClamped(iLon+1, iLat)
+	v01 := m.getClamped(iLon, iLat+1)
+	v11 := m.getClamped(iLon+1, iLat+1)
+
+	// Lagrange polynoms: pij = is 1 on corner ij and 0 elsewhere
+	p00 := (1 - s) * (1 - t)
+	p10 := s * (1 - t)
+	p01 := (1 - s) * t
+	p11 := s * t
+
+	return v00*p00 + v10*p10 + v01*p01 + v11*p11
+}
+
+func catmullRom(v0, v1, v2, v3, x float32) float32 {
+	return v1 + 0.5*x*(v2-v0+x*(2*v0-5*v1+4*v2-v3+x*(3*(v1-v2)+v3-v0)))
+}
+
+func (m *depthMap) bicubic(iLon, iLat int, s, t float32) float32 {
+	var col [4]float32
+	for k := 0; k < 4; k++ {
+		i := iLon + k - 1
+		col[k] = catmullRom(m.getClamped(i, iLat-1), m.getClamped(i, iLat),
+			m.getClamped(i, iLat+1), m.getClamped(i, iLat+2), t)
+	}
+	v := catmullRom(col[0], col[1], col[2], col[3], s)
+
+	// Catmull-Rom overshoots at steps, e.g. next to an area without snow it would
+	// go negative. So stay within the range of the tile's corners.
+	lo := float32(math.MaxFloat32)
+	hi := float32(-math.MaxFloat32)
+	for _, c := range [4]float32{m.getClamped(iLon, iLat), m.getClamped(iLon+1, iLat),
+		m.getClamped(iLon, iLat+1), m.getClamped(iLon+1, iLat+1)} {
+		lo = min(lo, c)
+		hi = max(hi, c)
+	}
+	return min(max(v, lo), hi)
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var allInterpolations = []Interpolation{InterpBilinear, InterpNearest, InterpBicubic, InterpSmoothStep}
+
+// 10° world grid starting at the antimeridian with a snow field around it
+func antimeridianMap() *depthMap {
+	g := Grid{Lon0: -180, Lat0: -90, DLon: 10, DLat: 10, NLon: 36, NLat: 19, WrapLon: true}
+	m := newDepthMap(newMockLogger(), "antimeridian", g)
+	for j := 0; j < g.NLat; j++ {
+		m.set(0, j, 1.0)  // 180°W
+		m.set(35, j, 0.5) // 170°E
+		m.set(1, j, 0.25) // 170°W
+	}
+	return m
+}
+
+func TestInterpolationAntimeridian(t *testing.T) {
+	m := antimeridianMap()
+	for _, ip := range allInterpolations {
+		m = m.withInterpolation(ip)
+
+		// on grid points all kernels return the grid value
+		assert.InDelta(t, 1.0, m.Get(-180, 0), 1e-5, ip.String())
+		assert.InDelta(t, 1.0, m.Get(180, 0), 1e-5, ip.String())
+		assert.InDelta(t, 0.5, m.Get(170, 0), 1e-5, ip.String())
+		assert.InDelta(t, 0.25, m.Get(-170, 0), 1e-5, ip.String())
+
+		// continuous across the antimeridian
+		assert.InDelta(t, m.Get(179.999, 20), m.Get(-179.999, 20), 1e-3, ip.String())
+
+		// between the grid points we stay in range
+		for lon := float32(160); lon <= 200; lon += 0.5 {
+			v := m.Get(lon, 33)
+			assert.GreaterOrEqual(t, v, float32(0), ip.String())
+			assert.LessOrEqual(t, v, float32(1), ip.String())
+		}
+	}
+
+	m = m.withInterpolation(InterpNearest)
+	assert.Equal(t, float32(0.5), m.Get(174, 0))
+	assert.Equal(t, float32(1.0), m.Get(176, 0))
+	assert.Equal(t, float32(1.0), m.Get(-176, 0))
+
+	m = m.withInterpolation(InterpBilinear)
+	assert.InDelta(t, 0.75, m.Get(175, 0), 1e-5)
+
+	m = m.withInterpolation(InterpSmoothStep)
+	assert.InDelta(t, 0.75, m.Get(175, 0), 1e-5)
+	assert.Less(t, m.Get(172, 0), float32(0.5+0.2*0.5)) // flat near the grid point
+}
+
+func TestInterpolationPoles(t *testing.T) {
+	m := newDepthMap(newMockLogger(), "poles", NewGlobalGrid(10, 10))
+	for i := 0; i < m.grid.NLon; i++ {
+		m.set(i, 18, 2.0) // north pole
+		m.set(i, 17, 1.0)
+		m.set(i, 0, 0.5) // south pole
+	}
+
+	for _, ip := range allInterpolations {
+		m = m.withInterpolation(ip)
+		assert.InDelta(t, 2.0, m.Get(0, 90), 1e-5, ip.String())
+		assert.InDelta(t, 2.0, m.Get(-123, 90), 1e-5, ip.String())
+		assert.InDelta(t, 0.5, m.Get(77, -90), 1e-5, ip.String())
+
+		v := m.Get(45, 85)
+		assert.GreaterOrEqual(t, v, float32(1), ip.String())
+		assert.LessOrEqual(t, v, float32(2), ip.String())
+
+		v = m.Get(45, -85)
+		assert.GreaterOrEqual(t, v, float32(0), ip.String())
+		assert.LessOrEqual(t, v, float32(0.5), ip.String())
+	}
+}
+
+func TestBicubicNoNegative(t *testing.T) {
+	// a single snow cell next to no snow makes Catmull-Rom undershoot
+	m := newDepthMap(newMockLogger(), "step", NewGrid(0, 0, 1, 1, 8, 8))
+	m.set(3, 3, 1.0)
+	m.set(4, 3, 1.0)
+	m = m.withInterpolation(InterpBicubic)
+
+	for x := float32(0); x <= 7; x += 0.1 {
+		for y := float32(0); y <= 7; y += 0.1 {
+			v := m.Get(x, y)
+			assert.GreaterOrEqual(t, v, float32(0))
+			assert.LessOrEqual(t, v, float32(1))
+		}
+	}
+
+	ip, err := ParseInterpolation("Catmull-Rom")
+	assert.NoError(t, err)
+	assert.Equal(t, InterpBicubic, ip)
+	_, err = ParseInterpolation("lanczos")
+	assert.Error(t, err)
+}
+
+func TestWithInterpolationCopy(t *testing.T) {
+	m := antimeridianMap()
+	m.interp = InterpNearest
+	c := m.withInterpolation(InterpBilinear)
+
+	// same values, the kernel of the original is kept
+	assert.Equal(t, InterpNearest, m.interp)
+	assert.Equal(t, float32(1.0), m.Get(176, 0))
+	assert.InDelta(t, 0.8, c.Get(176, 0), 1e-5)
+	assert.Equal(t, m.GetIdx(35, 0), c.GetIdx(35, 0))
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"fmt"
+	"github.com/xairline/xa-snow/utils/logger"
+	"image"
+	"image/png"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Land cover classes from a world raster in plate carrée, row 0 at 90°N, column 0 at 180°W.
+// The pixel value is the class: the gray level of a gray scale PNG, the palette index of a paletted
+// one, red otherwise. Any resolution works, ESA CCI land cover resampled to ~0.1° is a good fit.
+//
+// Per class rules correct the snow depth:
+//
+//	<class>:cap:<m>     at most m
+//	<class>:scale:<f>   multiplied by f
+//	<class>:skip        no snow
+//
+// The defaults use the ESA CCI LCCS codes: permanent snow and ice (220), where GFS reports
+// enormous depths, is capped and urban areas (190) get half of the snow.
+const DefaultLandCoverRules = "220:cap:0.5,190:scale:0.5"
+
+type LandCoverAction int
+
+const (
+	LandCoverCap LandCoverAction = iota
+	LandCoverScale
+	LandCoverSkip
+)
+
+type LandCoverRule struct {
+	Action LandCoverAction
+	Value  float32 // cap in m or factor
+}
+
+func (r LandCoverRule) apply(sd float32) float32 {
+	switch r.Action {
+	case LandCoverCap:
+		return min(sd, r.Value)
+	case LandCoverScale:
+		return sd * r.Value
+	}
+	return 0
+}
+
+type LandCoverRules map[uint8]LandCoverRule
+
+func ParseLandCoverRules(spec string) (LandCoverRules, error) {
+	rules := make(LandCoverRules)
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		f := strings.Split(rule, ":")
+		class, err := strconv.ParseUint(strings.TrimSpace(f[0]), 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("rule '%s': invalid class", rule)
+		}
+
+		var r LandCoverRule
+		switch strings.ToLower(strings.TrimSpace(f[min(1, len(f)-1)])) {
+		case "cap":
+			r.Action = LandCoverCap
+		case "scale":
+			r.Action = LandCoverScale
+		case "skip":
+			r.Action = LandCoverSkip
+		default:
+			return nil, fmt.Errorf("rule '%s': action must be cap, scale or skip", rule)
+		}
+
+		if r.Action == LandCoverSkip {
+			if len(f) != 2 {
+				return nil, fmt.Errorf("rule '%s': skip has no argument", rule)
+			}
+		} else {
+			if len(f) != 3 {
+				return nil, fmt.Errorf("rule '%s': missing value", rule)
+			}
+			v, err := argFloat(f[2])
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("rule '%s': invalid value", rule)
+			}
+			r.Value = v
+		}
+
+		if _, dup := rules[uint8(class)]; dup {
+			return nil, fmt.Errorf("rule '%s': class %d already has a rule", rule, class)
+		}
+		rules[uint8(class)] = r
+	}
+	return rules, nil
+}
+
+func (rules LandCoverRules) String() string {
+	names := [...]string{"cap", "scale", "skip"}
+	classes := make([]int, 0, len(rules))
+	for c := range rules {
+		classes = append(classes, int(c))
+	}
+	sort.Ints(classes)
+
+	s := make([]string, len(classes))
+	for i, c := range classes {
+		r := rules[uint8(c)]
+		s[i] = fmt.Sprintf("%d:%s", c, names[r.Action])
+		if r.Action != LandCoverSkip {
+			s[i] += ":" + strconv.FormatFloat(float64(r.Value), 'g', -1, 32)
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+type LandCoverMap struct {
+	grid  Grid    // pixel centers, wraps in lon
+	class []uint8 // [iLon * NLat + iLat]
+	Rules LandCoverRules
+}
+
+func NewLandCoverMap(img image.Image, rules LandCoverRules) (*LandCoverMap, error) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w < 2 || h < 2 {
+		return nil, fmt.Errorf("land cover raster is too small: %dx%d", w, h)
+	}
+
+	dlon, dlat := 360/float32(w), 180/float32(h)
+	lc := &LandCoverMap{
+		grid:  Grid{Lon0: -180 + dlon/2, Lat0: -90 + dlat/2, DLon: dlon, DLat: dlat, NLon: w, NLat: h, WrapLon: true},
+		class: make([]uint8, w*h),
+		Rules: rules,
+	}
+
+	for y := 0; y < h; y++ {
+		j := h - 1 - y
+		for x := 0; x < w; x++ {
+			var c uint8
+			switch im := img.(type) {
+			case *image.Gray:
+				c = im.GrayAt(b.Min.X+x, b.Min.Y+y).Y
+			case *image.Paletted:
+				c = im.ColorIndexAt(b.Min.X+x, b.Min.Y+y)
+			default:
+				r, _, _, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				c = uint8(r >> 8)
+			}
+			lc.class[x*h+j] = c
+		}
+	}
+	return lc, nil
+}
+
+func LoadLandCoverMap(logger logger.Logger, path string, rules LandCoverRules) (*LandCoverMap, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	lc, err := NewLandCoverMap(img, rules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	logger.Infof("Land cover '%s' loaded, %dx%d, rules: %s", path, lc.grid.NLon, lc.grid.NLat, rules.String())
+	return lc, nil
+}
+
+// class of the nearest pixel
+func (lc *LandCoverMap) Class(lon, lat float32) uint8 {
+	fLon, fLat := lc.grid.FIdx(lon, lat)
+	i, j := lc.grid.clamp(int(fLon+0.5), int(fLat+0.5))
+	return lc.class[i*lc.grid.NLat+j]
+}
+
+// apply the rules to every grid point of snow
+func (lc *LandCoverMap) Correct(snow *depthMap) (*depthMap, int) {
+	n := 0
+	out := mapCells(snow, snow.name, func(i, j int, v float32) float32 {
+		if v <= 0 {
+			return v
+		}
+		r, ok := lc.Rules[lc.Class(snow.grid.LonLat(i, j))]
+		if !ok {
+			return v
+		}
+		n++
+		return r.apply(v)
+	})
+	return out, n
+}
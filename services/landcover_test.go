@@ -0,0 +1,87 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 10° pixels: urban (190) east of 0° north of the equator, ice (220) south of 60°S, fields (10) elsewhere
+func landCoverImage() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, 36, 18))
+	for y := 0; y < 18; y++ {
+		for x := 0; x < 36; x++ {
+			c := uint8(10)
+			if y >= 15 {
+				c = 220
+			} else if y < 9 && x >= 18 {
+				c = 190
+			}
+			img.SetGray(x, y, color.Gray{Y: c})
+		}
+	}
+	return img
+}
+
+func TestParseLandCoverRules(t *testing.T) {
+	rules, err := ParseLandCoverRules(DefaultLandCoverRules + ", 210:skip")
+	assert.NoError(t, err)
+	assert.Equal(t, LandCoverRule{LandCoverCap, 0.5}, rules[220])
+	assert.Equal(t, LandCoverRule{LandCoverScale, 0.5}, rules[190])
+	assert.Equal(t, LandCoverRule{LandCoverSkip, 0}, rules[210])
+	assert.Equal(t, "190:scale:0.5,210:skip,220:cap:0.5", rules.String())
+
+	for _, spec := range []string{"300:cap:1", "x:cap:1", "220:cap", "220:skip:1", "220:melt:1", "220:cap:-1", "220:cap:1,220:skip"} {
+		_, err := ParseLandCoverRules(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestLandCover(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "landcover.png")
+	f, err := os.Create(fn)
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(f, landCoverImage()))
+	f.Close()
+
+	rules, _ := ParseLandCoverRules("220:cap:0.5,190:scale:0.5,10:skip")
+	lc, err := LoadLandCoverMap(newMockLogger(), fn, rules)
+	assert.NoError(t, err)
+
+	assert.Equal(t, uint8(190), lc.Class(9.3, 51.4))
+	assert.Equal(t, uint8(10), lc.Class(-75, 40))
+	assert.Equal(t, uint8(220), lc.Class(0, -90))
+	assert.Equal(t, uint8(10), lc.Class(-179.99, 0.01)) // wraps around
+	assert.Equal(t, uint8(190), lc.Class(179.99, 89.99))
+
+	snow := newDepthMap(newMockLogger(), "Snow", NewGlobalGrid(1, 1))
+	set := func(lon, lat, sd float32) {
+		i, j, _ := snow.grid.Idx(lon, lat)
+		snow.set(i, j, sd)
+	}
+	set(9, 51, 0.4)
+	set(-75, 40, 0.4)
+	set(0, -80, 3)
+	set(0, -50, 3)
+
+	pc := &PipelineContext{Logger: newMockLogger(), LandCover: lc}
+	p, err := ParsePipeline("landcover")
+	assert.NoError(t, err)
+	out, err := p.Run(pc, snow)
+	assert.NoError(t, err)
+
+	assert.InDelta(t, 0.2, out.Get(9, 51), 1e-6)
+	assert.Equal(t, float32(0), out.Get(-75, 40))
+	assert.InDelta(t, 0.5, out.Get(0, -80), 1e-6)
+	assert.Equal(t, float32(0), out.Get(0, -50)) // class 10 skipped
+
+	// without land cover the stage does nothing
+	pc.LandCover = nil
+	out, err = p.Run(pc, snow)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(3), out.Get(0, -80))
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"fmt"
+	"github.com/xairline/xa-snow/utils/logger"
+	"math"
+)
+
+// Sub-grid variability: multi-octave value noise keyed on lat/lon breaks up thin snow cover into
+// patches while deep snow stays uniform. The noise only depends on the seed and the position so
+// every seat of a multi-seat setup with the same seed sees the same patches.
+//
+//	p  = Amount * terrain * 2 * (1 - sd / Full), clamped to [0, 1]
+//	sd = sd * ((1 - p) + p * f(noise))
+//
+// f is 0 in bare patches and 2 where snow drifted together, averaging 1 so the mean depth stays
+// the same. With a DEM rugged terrain gets up to twice the variability of flat land.
+type SnowNoise struct {
+	Seed    uint64
+	Amount  float32 // 0 = off, 1 = snow below Full / 2 fully patchy
+	Scale   float32 // km, size of the largest patches
+	Octaves int
+	Full    float32       // m, depth from which on snow is uniform
+	Elev    *ElevationMap // nil: flat terrain
+}
+
+var DefaultSnowNoise = SnowNoise{Seed: 1, Amount: 0, Scale: 3, Octaves: 4, Full: 0.15}
+
+const (
+	noiseContrast  = 3     // bare and drift areas instead of a gentle wave
+	noiseRoughness = 300.0 // m of elevation difference to the neighbours for full terrain effect
+)
+
+func (n *SnowNoise) String() string {
+	return fmt.Sprintf("amount: %0.2f, seed: %d, scale: %0.1f km, octaves: %d, full: %0.2f m",
+		n.Amount, n.Seed, n.Scale, n.Octaves, n.Full)
+}
+
+// nil if SNOW_NOISE is not set or 0
+func SnowNoiseFromEnv(logger logger.Logger, elev *ElevationMap) *SnowNoise {
+	n := DefaultSnowNoise
+	n.Amount = envFloat(logger, "SNOW_NOISE", n.Amount)
+	if n.Amount <= 0 {
+		return nil
+	}
+	n.Amount = min(n.Amount, 1)
+	n.Seed = uint64(envInt(logger, "SNOW_NOISE_SEED", int(n.Seed)))
+	n.Scale = envFloat(logger, "SNOW_NOISE_SCALE", n.Scale)
+	n.Octaves = envInt(logger, "SNOW_NOISE_OCTAVES", n.Octaves)
+	n.Full = envFloat(logger, "SNOW_NOISE_FULL", n.Full)
+	if n.Scale <= 0 || n.Octaves < 1 || n.Full <= 0 {
+		logger.Errorf("Invalid snow noise settings: %s", n.String())
+		return nil
+	}
+	n.Elev = elev
+	return &n
+}
+
+// splitmix64 finalizer
+func noiseHash(seed uint64, ix, iy int64, octave int) uint64 {
+	h := seed ^ uint64(ix)*0x9e3779b97f4a7c15 ^ uint64(iy)*0xc2b2ae3d27d4eb4f ^ uint64(octave)*0x165667b19e3779f9
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h
+}
+
+// value at a lattice point in [0, 1)
+func (n *SnowNoise) lattice(ix, iy int64, octave int) float64 {
+	return float64(noiseHash(n.Seed, ix, iy, octave)>>11) / (1 << 53)
+}
+
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+// fractal value noise in [0, 1], coordinates in km
+func (n *SnowNoise) fbm(x, y float64) float64 {
+	sum, total := 0.0, 0.0
+	amp := 1.0
+	for o := 0; o < n.Octaves; o++ {
+		fx, fy := math.Floor(x), math.Floor(y)
+		tx, ty := fade(x-fx), fade(y-fy)
+		ix, iy := int64(fx), int64(fy)
+		v00 := n.lattice(ix, iy, o)
+		v10 := n.lattice(ix+1, iy, o)
+		v01 := n.lattice(ix, iy+1, o)
+		v11 := n.lattice(ix+1, iy+1, o)
+		v := (v00*(1-tx)+v10*tx)*(1-ty) + (v01*(1-tx)+v11*tx)*ty
+
+		sum += amp * v
+		total += amp
+		amp /= 2
+		x, y = 2*x, 2*y
+	}
+	return sum / total
+}
+
+// noise at a position, the seam at the antimeridian is over the Pacific
+func (n *SnowNoise) Value(lon, lat float32) float32 {
+	lon = float32(math.Mod(float64(lon)+540, 360) - 180)
+	km := lat2m / 1000 / float64(n.Scale)
+	x := float64(lon) * math.Cos(float64(lat)*math.Pi/180) * km
+	y := float64(lat) * km
+	return float32(n.fbm(x, y))
+}
+
+// 1 on flat land up to 2 in rugged terrain
+func (n *SnowNoise) terrain(lon, lat float32) float32 {
+	if n.Elev == nil {
+		return 1
+	}
+	g := n.Elev.Grid()
+	i, j, ok := g.Idx(lon, lat)
+	if !ok {
+		return 1
+	}
+	h, ok := n.Elev.GetIdx(i, j)
+	if !ok {
+		return 1
+	}
+
+	d, cnt := float32(0), 0
+	for _, o := range [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}} {
+		if hn, ok := n.Elev.GetIdx(i+o[0], j+o[1]); ok {
+			d += abs32(hn - h)
+			cnt++
+		}
+	}
+	if cnt == 0 {
+		return 1
+	}
+	return 1 + min(1, d/float32(cnt)/noiseRoughness)
+}
+
+// modulated snow depth at a position
+func (n *SnowNoise) Apply(lon, lat, sd float32) float32 {
+	if sd <= 0 || sd >= n.Full {
+		return sd
+	}
+
+	p := min(1, n.Amount*n.terrain(lon, lat)*2*(1-sd/n.Full))
+	t := min(1, max(0, (n.Value(lon, lat)-0.5)*noiseContrast+0.5))
+	f := 2 * float32(fade(float64(t)))
+	return sd * ((1 - p) + p*f)
+}
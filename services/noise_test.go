@@ -0,0 +1,75 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSnowNoise(t *testing.T) {
+	n := DefaultSnowNoise
+	n.Amount = 1
+
+	// reproducible, varies with the seed
+	v := n.Value(10.123, 47.456)
+	assert.Equal(t, v, n.Value(10.123, 47.456))
+	m := n
+	m.Seed = 2
+	assert.NotEqual(t, v, m.Value(10.123, 47.456))
+	assert.Equal(t, n.Value(-170, 10), n.Value(190, 10))
+
+	// range, mean depth is kept, thin snow has bare patches
+	sum, bare := float64(0), 0
+	lo, hi := float32(1), float32(0)
+	const N = 100
+	for i := 0; i < N; i++ {
+		for j := 0; j < N; j++ {
+			lon, lat := 10+float32(i)*0.01, 47+float32(j)*0.01
+			v := n.Value(lon, lat)
+			lo, hi = min(lo, v), max(hi, v)
+
+			sd := n.Apply(lon, lat, 0.02)
+			assert.GreaterOrEqual(t, sd, float32(0))
+			assert.LessOrEqual(t, sd, float32(0.04))
+			if sd < 0.001 {
+				bare++
+			}
+			sum += float64(sd)
+		}
+	}
+	assert.GreaterOrEqual(t, lo, float32(0))
+	assert.LessOrEqual(t, hi, float32(1))
+	assert.Greater(t, hi-lo, float32(0.5))
+	assert.InEpsilon(t, 0.02, sum/(N*N), 0.1)
+	assert.Greater(t, bare, N*N/20)
+
+	// deep snow and no snow stay
+	assert.Equal(t, float32(0.3), n.Apply(10.5, 47.5, 0.3))
+	assert.Equal(t, float32(0), n.Apply(10.5, 47.5, 0))
+
+	// less variability for less amount
+	n.Amount = 0.2
+	for i := 0; i < N; i++ {
+		sd := n.Apply(10+float32(i)*0.01, 47, 0.02)
+		assert.InDelta(t, 0.02, sd, 0.4*0.02+1e-6)
+	}
+}
+
+func TestSnowNoiseTerrain(t *testing.T) {
+	g := NewGrid(10, 47, 0.1, 0.1, 10, 10)
+	e := &ElevationMap{grid: g, val: make([]int16, g.Size())}
+	for i := 0; i < 10; i++ {
+		for j := 0; j < 10; j++ {
+			if i >= 5 {
+				e.val[i*g.NLat+j] = int16(300 * ((i + j) % 2)) // rugged in the east
+			}
+		}
+	}
+	e.val[0] = elevNoData
+
+	n := DefaultSnowNoise
+	n.Elev = e
+	assert.Equal(t, float32(1), n.terrain(10.2, 47.2))
+	assert.Equal(t, float32(2), n.terrain(10.7, 47.2))
+	assert.Equal(t, float32(1), n.terrain(10, 47))
+	assert.Equal(t, float32(1), n.terrain(20, 47))
+}
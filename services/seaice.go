@@ -0,0 +1,98 @@
+package services
+
+import (
+	"fmt"
+	"github.com/xairline/xa-snow/utils/coast"
+	"github.com/xairline/xa-snow/utils/logger"
+)
+
+// The coast map is static, but in late winter the Baltic, Hudson Bay or the Sea of Okhotsk freeze.
+// With the sea ice concentration (GFS ICEC, 0..1) sea with at least Threshold ice is land for the
+// coast map, so coastal snow is extended to the ice edge instead of the summer shore line, and the
+// "seaice" stage puts Depth * concentration of snow on it.
+type SeaIce struct {
+	Conc      *depthMap // global grid, nil until loaded
+	Threshold float32   // 0 = off
+	Depth     float32   // m of snow on full ice cover
+}
+
+var DefaultSeaIce = SeaIce{Threshold: 0.5, Depth: 0.1}
+
+func (s *SeaIce) String() string {
+	return fmt.Sprintf("threshold: %0.2f, depth: %0.2f m", s.Threshold, s.Depth)
+}
+
+// nil if SNOW_SEA_ICE is 0
+func SeaIceFromEnv(logger logger.Logger) *SeaIce {
+	s := DefaultSeaIce
+	s.Threshold = envFloat(logger, "SNOW_SEA_ICE", s.Threshold)
+	if s.Threshold <= 0 {
+		return nil
+	}
+	s.Depth = envFloat(logger, "SNOW_SEA_ICE_DEPTH", s.Depth)
+	if s.Threshold > 1 || s.Depth < 0 {
+		logger.Errorf("Invalid sea ice settings: %s", s.String())
+		return nil
+	}
+	return &s
+}
+
+// concentration from a csv file in the format of the snow depth
+func (s *SeaIce) Load(logger logger.Logger, csv_name string) error {
+	m := newDepthMapStorage(logger, "Sea ice", GlobalGrid, StorageSparse)
+	report, err := m.LoadCsv(csv_name)
+	if err == nil && report.Max > 1 {
+		err = fmt.Errorf("'%s': concentration %0.1f is not a fraction", csv_name, report.Max)
+	}
+	if err == nil {
+		err = report.Validate()
+	}
+	if err != nil {
+		return err
+	}
+	s.Conc = m
+	return nil
+}
+
+// at global indices
+func (s *SeaIce) Frozen(i, j int) bool {
+	return s.Conc.GetIdx(i, j) >= s.Threshold
+}
+
+// cs with frozen water as land, cs itself if its coast map can't be changed
+func (s *SeaIce) Coast(logger logger.Logger, cs CoastService) CoastService {
+	cm, ok := fastCoast(cs).(*coast.Map)
+	if !ok {
+		logger.Warning("Sea ice: the coast map can't be changed")
+		return cs
+	}
+	frozen := cm.WithLand(s.Frozen)
+	logger.Infof("Sea ice: %d coast points, %d without ice", len(frozen.CoastPoints()), len(cm.CoastPoints()))
+	return frozen
+}
+
+// snow on frozen points, in is not modified
+func (s *SeaIce) Apply(in *depthMap) (*depthMap, error) {
+	g := &in.grid
+	di, dj, ok := g.offsetIn(&GlobalGrid)
+	if !ok {
+		return nil, fmt.Errorf("unsupported grid %s", g.String())
+	}
+
+	out := in.clone("Snow + Sea ice")
+	n := 0
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			c := s.Conc.GetIdx(i+di, j+dj)
+			if c < s.Threshold {
+				continue
+			}
+			if sd := s.Depth * c; sd > out.at(i, j) {
+				out.set(i, j, sd)
+				n++
+			}
+		}
+	}
+	in.Logger.Infof("Snow on sea ice on %d grid points", n)
+	return out, nil
+}
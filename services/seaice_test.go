@@ -0,0 +1,81 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xairline/xa-snow/utils/coast"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSeaIceLoad(t *testing.T) {
+	s := DefaultSeaIce
+	// a few points of the global grid are a truncated file
+	assert.ErrorContains(t, s.Load(newMockLogger(), "../testdata/EDVK_icec.csv"), "truncated")
+	assert.Nil(t, s.Conc)
+
+	// percent instead of a fraction
+	fn := filepath.Join(t.TempDir(), "icec.csv")
+	os.WriteFile(fn, []byte("longitude, latitude, value,\n9.3, 51.4, 80.0,\n"), 0644)
+	assert.ErrorContains(t, s.Load(newMockLogger(), fn), "not a fraction")
+	assert.Error(t, s.Load(newMockLogger(), "../testdata/no_such_file.csv"))
+}
+
+func TestSeaIce(t *testing.T) {
+	// sea west of i = 197, land up to 1797
+	img := image.NewGray(image.Rect(0, 0, n_iLon, n_iLat-1))
+	for y := 0; y < n_iLat-1; y++ {
+		for x := 2000; x < n_iLon; x++ {
+			img.SetGray(x, y, color.Gray{255})
+		}
+	}
+	cm, err := coast.New(img, coast.DefaultOffset)
+	assert.NoError(t, err)
+	yes, _, _, _ := cm.IsCoast(196, 1500)
+	assert.True(t, yes)
+
+	// snow on land, the sea is frozen up to 12 points off the coast
+	in := newDepthMap(newMockLogger(), "Snow", GlobalGrid)
+	conc := newDepthMapStorage(newMockLogger(), "Sea ice", GlobalGrid, StorageSparse)
+	for j := 1400; j < 1600; j++ {
+		for i := 197; i < 300; i++ {
+			in.set(i, j, 0.3)
+		}
+		for i := 185; i < 197; i++ {
+			conc.set(i, j, 0.9)
+		}
+		conc.set(150, j, 0.3) // drift ice
+	}
+	ice := &SeaIce{Conc: conc, Threshold: 0.5, Depth: 0.1}
+
+	frozen := ice.Coast(newMockLogger(), cm)
+	assert.True(t, frozen.IsLand(190, 1500))
+	assert.True(t, frozen.IsWater(150, 1500))
+	yes, dx, _, _ := frozen.IsCoast(184, 1500)
+	assert.True(t, yes)
+	assert.Equal(t, 1, dx)
+	yes, _, _, _ = frozen.IsCoast(196, 1500)
+	assert.False(t, yes)
+	// the coast map itself is unchanged
+	assert.True(t, cm.IsWater(190, 1500))
+
+	// the coast can't be changed without a Go coast map
+	assert.Equal(t, stubCoast{}, ice.Coast(newMockLogger(), stubCoast{}))
+
+	p, err := ParsePipeline("seaice,coast")
+	assert.NoError(t, err)
+	out, err := p.Run(&PipelineContext{Logger: newMockLogger(), Cs: frozen, SeaIce: ice}, in)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.09, out.GetIdx(190, 1500), 1e-4)
+	assert.Equal(t, float32(0), out.GetIdx(150, 1500))
+	assert.InDelta(t, 0.09*0.8, out.GetIdx(184, 1500), 1e-4)
+	assert.Equal(t, float32(0), in.GetIdx(190, 1500))
+
+	// open sea
+	out, err = p.Run(&PipelineContext{Logger: newMockLogger(), Cs: cm}, in)
+	assert.NoError(t, err)
+	assert.Equal(t, float32(0), out.GetIdx(184, 1500))
+	assert.Equal(t, float32(0), out.GetIdx(190, 1500))
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"math"
+)
+
+// Mass conserving smoothing against the blocky 0.25° GFS cells. Neighbours exchange snow
+// volume (depth * cell area) with symmetric weights, what one point gains the other loses, so the
+// total volume stays the same and a uniform area stays as it is. The filter is separable and
+// runs in lon, then in lat.
+//
+//	gaussian: w = exp(-d² / 2 sigma²)
+//	bilateral: w = exp(-d² / 2 sigma²) * exp(-(sd_p - sd_q)² / 2 range²), edges between
+//	           areas of very different depth are kept
+//
+// With a coast map there is no exchange between land and water.
+type smoothFilter struct {
+	sigma float32   // in grid points
+	rng   float32   // m, 0 for a gaussian filter
+	w     []float32 // spatial weights for distances 0..radius
+}
+
+// grid points the filter reaches in each direction
+func smoothRadius(sigma float32) int {
+	return int(math.Ceil(float64(2.5 * sigma)))
+}
+
+func newSmoothFilter(sigma, rng float32) *smoothFilter {
+	r := smoothRadius(sigma)
+	f := &smoothFilter{sigma: sigma, rng: rng, w: make([]float32, r+1)}
+	for d := range f.w {
+		f.w[d] = float32(math.Exp(-float64(d*d) / (2 * float64(sigma*sigma))))
+	}
+	return f
+}
+
+func (f *smoothFilter) weight(d int, sd_p, sd_q float32) float32 {
+	if d < 0 {
+		d = -d
+	}
+	w := f.w[d]
+	if f.rng > 0 {
+		dv := sd_p - sd_q
+		w *= float32(math.Exp(-float64(dv*dv) / (2 * float64(f.rng*f.rng))))
+	}
+	return w
+}
+
+// area of the cells in each lat row relative to the equator, exact at the poles
+func cellAreas(g *Grid) []float32 {
+	a := make([]float32, g.NLat)
+	h := float64(g.DLat) / 2
+	for j := range a {
+		_, lat := g.LonLat(0, j)
+		hi := min(90, float64(lat)+h) * math.Pi / 180
+		lo := max(-90, float64(lat)-h) * math.Pi / 180
+		a[j] = float32((math.Sin(hi) - math.Sin(lo)) / (2 * math.Sin(h*math.Pi/180)))
+	}
+	return a
+}
+
+// total snow volume in (m * equatorial cell areas)
+func snowVolume(dm *depthMap) float64 {
+	g := &dm.grid
+	area := cellAreas(g)
+	v := float64(0)
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			v += float64(dm.at(i, j) * area[j])
+		}
+	}
+	return v
+}
+
+// class is nil or returns the kind of a grid point, snow stays within its kind
+func smoothMap(in *depthMap, f *smoothFilter, class func(i, j int) bool) *depthMap {
+	g := &in.grid
+	r := len(f.w) - 1
+	same := func(i, j, ii, jj int) bool {
+		return class == nil || class(i, j) == class(ii, jj)
+	}
+
+	// normalized by the full stencil, so the point keeps the weights of excluded neighbours
+	norm := f.w[0]
+	for d := 1; d <= r; d++ {
+		norm += 2 * f.w[d]
+	}
+
+	// in lon, all cells of a row have the same area
+	tmp := make([]float32, g.Size())
+	for i := 0; i < g.NLon; i++ {
+		for j := 0; j < g.NLat; j++ {
+			sd := in.at(i, j)
+			acc := sd
+			for d := -r; d <= r; d++ {
+				ii, _, ok := g.wrap(i+d, j)
+				if d == 0 || !ok {
+					continue
+				}
+				sq := in.at(ii, j)
+				if sq == sd || !same(i, j, ii, j) {
+					continue
+				}
+				acc += f.weight(d, sd, sq) / norm * (sq - sd)
+			}
+			tmp[i*g.NLat+j] = acc
+		}
+	}
+
+	// in lat, the volume exchanged is limited by the smaller cell
+	area := cellAreas(g)
+	out := derivedMap(in, in.name)
+	for i := 0; i < g.NLon; i++ {
+		row := tmp[i*g.NLat : (i+1)*g.NLat]
+		for j, sd := range row {
+			acc := sd * area[j]
+			for d := -r; d <= r; d++ {
+				jj := j + d
+				if d == 0 || jj < 0 || jj >= g.NLat {
+					continue
+				}
+				sq := row[jj]
+				if sq == sd || !same(i, j, i, jj) {
+					continue
+				}
+				acc += f.weight(d, sd, sq) / norm * (sq - sd) * min(area[j], area[jj])
+			}
+			out.set(i, j, acc/area[j])
+		}
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// snow block with a sharp 0.25° edge
+func blockSnow(grid Grid, i0, j0, n int, sd float32) *depthMap {
+	m := newDepthMap(newMockLogger(), "Snow", grid)
+	for i := i0; i < i0+n; i++ {
+		for j := j0; j < j0+n; j++ {
+			ii, jj, ok := grid.wrap(i, j)
+			if ok {
+				m.set(ii, jj, sd)
+			}
+		}
+	}
+	return m
+}
+
+func TestSmoothMassConservation(t *testing.T) {
+	cases := []struct {
+		name   string
+		in     *depthMap
+		filter *smoothFilter
+		class  func(i, j int) bool
+	}{
+		{"gauss", blockSnow(GlobalGrid, 100, 1400, 25, 0.5), newSmoothFilter(2, 0), nil},
+		{"bilateral", blockSnow(GlobalGrid, 100, 1400, 25, 0.5), newSmoothFilter(2, 0.1), nil},
+		{"antimeridian", blockSnow(GlobalGrid, 3590, 900, 25, 0.3), newSmoothFilter(3, 0), nil},
+		{"pole", blockSnow(GlobalGrid, 0, 1790, 25, 1), newSmoothFilter(3, 0), nil},
+		{"masked", blockSnow(GlobalGrid, 10, 1400, 50, 0.5), newSmoothFilter(2, 0), stubCoast{}.IsWater},
+		{"regional", blockSnow(NewGrid(0, 40, 0.1, 0.1, 30, 30), -5, -5, 15, 0.4), newSmoothFilter(2, 0), nil},
+	}
+
+	for _, c := range cases {
+		out := smoothMap(c.in, c.filter, c.class)
+		assert.InEpsilon(t, snowVolume(c.in), snowVolume(out), 1e-5, c.name)
+	}
+}
+
+func TestSmoothEdges(t *testing.T) {
+	in := blockSnow(GlobalGrid, 100, 1400, 25, 0.5)
+	gauss := smoothMap(in, newSmoothFilter(2, 0), nil)
+	bilateral := smoothMap(in, newSmoothFilter(2, 0.1), nil)
+
+	// the edge is softened, the center stays
+	assert.Greater(t, gauss.at(99, 1410), float32(0.05))
+	assert.Less(t, gauss.at(100, 1410), float32(0.45))
+	assert.InDelta(t, 0.5, gauss.at(112, 1412), 1e-4)
+
+	// bilateral keeps more of it
+	assert.Less(t, bilateral.at(99, 1410), gauss.at(99, 1410))
+	assert.Greater(t, bilateral.at(100, 1410), gauss.at(100, 1410))
+
+	// no snow is moved across the coast
+	var cs stubCoast
+	land := blockSnow(GlobalGrid, 30, 1400, 50, 0.5)
+	masked := smoothMap(land, newSmoothFilter(2, 0), cs.IsWater)
+	unmasked := smoothMap(land, newSmoothFilter(2, 0), nil)
+	for i := 0; i < 30; i++ {
+		assert.Equal(t, float32(0), masked.at(i, 1420), "%d", i)
+	}
+	assert.Greater(t, unmasked.at(29, 1420), float32(0))
+	assert.InDelta(t, 0.5, masked.at(30, 1420), 1e-6) // land at the coast is not drained
+}
+
+func TestSmoothStage(t *testing.T) {
+	in := blockSnow(GlobalGrid, 140, 1400, 25, 0.5)
+	pc := &PipelineContext{Logger: newMockLogger(), Cs: stubCoast{}, Maps: map[string]DepthMap{"raw": in}}
+
+	p, err := ParsePipeline("gauss:1.5,bilateral:1:0.05,coast")
+	assert.NoError(t, err)
+	out, err := p.Run(pc, in)
+	assert.NoError(t, err)
+	assert.Greater(t, out.at(139, 1410), float32(0))
+	assert.InEpsilon(t, snowVolume(in), snowVolume(out), 1e-5)
+
+	for _, spec := range []string{"gauss", "gauss:0", "gauss:x", "bilateral:1", "bilateral:1:0"} {
+		_, err := ParsePipeline(spec)
+		assert.Error(t, err, spec)
+	}
+}
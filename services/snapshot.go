@@ -0,0 +1,82 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Result of one download and processing run. A snapshot is never modified after it has been
+// published so the flight loop can use it without locking while the next one is built.
+type SnowSnapshot struct {
+	Snow      DepthMap    // final map, used for GetSnowDepth
+	Raw       DepthMap    // as loaded from the grib file
+	Source    string      // grib or csv file
+	CycleTime time.Time   // UTC time of the model run, zero if unknown
+	Pipeline  string      // processing stages
+	Coast     CoastParams // coastal extension as used
+	Lakes     CoastParams // lake shores as used, Name is empty without a lake mask
+	SeaIce    *SeaIce     // nil without sea ice data
+	Noise     *SnowNoise  // patchiness for GetSnowDepth, nil if off
+	Created   time.Time
+}
+
+// how the snow map was made, one line for logs and export sidecars
+func (s *SnowSnapshot) Provenance() string {
+	cycle := "unknown"
+	if !s.CycleTime.IsZero() {
+		cycle = s.CycleTime.UTC().Format("2006-01-02 15Z")
+	}
+	noise := "off"
+	if s.Noise != nil {
+		noise = s.Noise.String()
+	}
+	lakes := "off"
+	if s.Lakes.Name != "" {
+		lakes = s.Lakes.String()
+	}
+	sea_ice := "off"
+	if s.SeaIce != nil {
+		sea_ice = s.SeaIce.String()
+	}
+	return fmt.Sprintf("source: %s, cycle: %s, pipeline: %s, coast: %s, lakes: %s, sea ice: %s, noise: %s",
+		s.Source, cycle, s.Pipeline, s.Coast.String(), lakes, sea_ice, noise)
+}
+
+// publishes snapshots, a snapshot of an outdated request is dropped
+type snapshotPublisher struct {
+	current    atomic.Pointer[SnowSnapshot]
+	lock       sync.Mutex // serializes generation changes and stores
+	generation uint64
+}
+
+func (p *snapshotPublisher) Load() *SnowSnapshot {
+	return p.current.Load()
+}
+
+// generation to be passed to Publish
+func (p *snapshotPublisher) Generation() uint64 {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.generation
+}
+
+// withdraw the current snapshot and invalidate all runs in progress
+func (p *snapshotPublisher) Invalidate() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.generation++
+	p.current.Store(nil)
+}
+
+// -> false if the snapshot is outdated
+func (p *snapshotPublisher) Publish(generation uint64, s *SnowSnapshot) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if generation != p.generation {
+		return false
+	}
+	p.current.Store(s)
+	return true
+}
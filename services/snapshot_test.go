@@ -0,0 +1,68 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+)
+
+func uniformSnapshot(v float32) *SnowSnapshot {
+	m := newDepthMap(newMockLogger(), "uniform", NewGlobalGrid(10, 10))
+	for i := 0; i < m.grid.NLon; i++ {
+		for j := 0; j < m.grid.NLat; j++ {
+			m.set(i, j, v)
+		}
+	}
+	return &SnowSnapshot{Snow: m, Raw: m}
+}
+
+func TestSnapshotPublish(t *testing.T) {
+	g := &gribService{Logger: newMockLogger()}
+	assert.False(t, g.IsReady())
+	assert.Equal(t, float32(0), g.GetSnowDepth(50, 10))
+
+	gen := g.snapshots.Generation()
+	assert.True(t, g.snapshots.Publish(gen, uniformSnapshot(1)))
+	assert.True(t, g.IsReady())
+
+	// readers must always see a complete map
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				snap := g.Snapshot()
+				v0 := snap.Snow.Get(0, 0)
+				v1 := snap.Snow.Get(170, -60)
+				if v0 != v1 {
+					t.Errorf("inconsistent snapshot %f != %f", v0, v1)
+					return
+				}
+				_ = g.GetSnowDepth(45, 7)
+			}
+		}()
+	}
+
+	for i := 2; i < 50; i++ {
+		assert.True(t, g.snapshots.Publish(gen, uniformSnapshot(float32(i))))
+	}
+	close(done)
+	wg.Wait()
+	assert.Equal(t, float32(49), g.GetSnowDepth(45, 7))
+
+	// a run started before SetNotReady must not publish
+	g.SetNotReady()
+	assert.False(t, g.IsReady())
+	assert.False(t, g.snapshots.Publish(gen, uniformSnapshot(1)))
+	assert.False(t, g.IsReady())
+
+	assert.True(t, g.snapshots.Publish(g.snapshots.Generation(), uniformSnapshot(2)))
+	assert.Equal(t, float32(2), g.GetSnowDepth(45, 7))
+}
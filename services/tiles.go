@@ -0,0 +1,394 @@
+package services
+
+import (
+	"container/list"
+	"fmt"
+	"github.com/xairline/xa-snow/utils/logger"
+	"math"
+	"sync"
+	"time"
+)
+
+// Instead of running the pipeline over the whole world the final map can be built in tiles of
+// SNOW_TILE_SIZE° x SNOW_TILE_SIZE°. A tile is processed with a margin as wide as the stages look at
+// neighbours (coast, blur, dem), so they see the same data as in a global run. Tiles within
+// SNOW_TILE_RADIUS of the aircraft and ahead on its track are prefetched in the background, at most
+// SNOW_TILE_CACHE tiles are kept and the least recently used ones are dropped. Processing never
+// happens in the caller's goroutine, a tile that is not ready yet is queued and the raw data is used
+// in the meantime.
+//
+// The raw map stays global as the grib data arrives for the whole world, DEPTH_MAP_STORAGE=quantized
+// or sparse keeps it small.
+const interpReach = 2 // grid points the widest kernel (bicubic) reads beyond a cell
+
+const (
+	DefaultTileRadius = 300 // km
+	DefaultTileCache  = 32
+)
+
+type tileKey struct {
+	ti, tj int // column from -180°, row from -90°
+}
+
+type tile struct {
+	key    tileKey
+	di, dj int // offset of the tile's grid on the raw grid
+	dm     *depthMap
+}
+
+// A DepthMap that is processed tile by tile on demand. All methods can be called concurrently,
+// the cache is the only thing that changes.
+type TiledDepthMap struct {
+	Logger   logger.Logger
+	name     string
+	size     float32 // tile size in °
+	nTi, nTj int
+	radius   float32 // m
+	maxTiles int
+	margin   int // grid points
+
+	raw      *depthMap
+	pipeline *Pipeline
+	pc       PipelineContext
+
+	lock      sync.Mutex
+	interp    Interpolation
+	lru       *list.List // of *tile, most recently used first
+	tiles     map[tileKey]*list.Element
+	pending   map[tileKey]bool // queued for prefetch
+	queue     []tileKey
+	running   bool // prefetch goroutine active
+	processed int
+}
+
+// size must be a whole number of degrees that divides 180, radius is in m
+func NewTiledDepthMap(pc *PipelineContext, pipeline *Pipeline, raw *depthMap, size, radius float32, max_tiles int, interp Interpolation) (*TiledDepthMap, error) {
+	if size < 1 || size != float32(math.Round(float64(size))) || 180%int(size) != 0 {
+		return nil, fmt.Errorf("tile size must be a whole number of degrees dividing 180, not %0.2f", size)
+	}
+	if !raw.grid.WrapLon || !raw.grid.polar() {
+		return nil, fmt.Errorf("tiles need a global map, got %s", raw.grid.String())
+	}
+	if max_tiles < 1 {
+		return nil, fmt.Errorf("tile cache must hold at least one tile")
+	}
+	margin := pipeline.Reach(pc, &raw.grid) + interpReach
+	if float32(margin)*max(raw.grid.DLon, raw.grid.DLat) > size {
+		return nil, fmt.Errorf("'%s' reads %d grid points around a point, too many for %0.0f° tiles",
+			pipeline.String(), margin-interpReach, size)
+	}
+
+	t := &TiledDepthMap{
+		Logger:   pc.Logger,
+		name:     raw.name + " (tiled)",
+		size:     size,
+		nTi:      int(360 / size),
+		nTj:      int(180 / size),
+		radius:   radius,
+		maxTiles: max_tiles,
+		margin:   margin,
+		raw:      raw,
+		pipeline: pipeline,
+		pc:       *pc,
+		interp:   interp,
+		lru:      list.New(),
+		tiles:    make(map[tileKey]*list.Element),
+		pending:  make(map[tileKey]bool),
+	}
+
+	g := t.tileGrid(tileKey{0, 0})
+	if _, _, ok := g.offsetIn(&raw.grid); !ok {
+		return nil, fmt.Errorf("tiles don't align with %s", raw.grid.String())
+	}
+	return t, nil
+}
+
+func (t *TiledDepthMap) Grid() *Grid {
+	return &t.raw.grid
+}
+
+func (t *TiledDepthMap) Name() string {
+	return t.name
+}
+
+func (t *TiledDepthMap) LoadCsv(csv_name string) (*LoadReport, error) {
+	return nil, fmt.Errorf("can't load into a tiled map")
+}
+
+func (t *TiledDepthMap) Get(lon, lat float32) float32 {
+	tl := t.cached(t.keyOf(lon, lat))
+	if tl == nil {
+		return t.raw.Get(lon, lat)
+	}
+	return tl.dm.Get(lon, lat)
+}
+
+// index on the raw map's grid
+func (t *TiledDepthMap) GetIdx(iLon, iLat int) float32 {
+	g := &t.raw.grid
+	iLon, iLat, _ = g.wrap(iLon, iLat)
+	tl := t.cached(t.keyOfIdx(iLon, iLat))
+	if tl == nil {
+		return t.raw.GetIdx(iLon, iLat)
+	}
+	i := (iLon - tl.di) % g.NLon
+	if i < 0 {
+		i += g.NLon
+	}
+	return tl.dm.GetIdx(i, iLat-tl.dj)
+}
+
+// # of cached tiles and # of tiles processed so far
+func (t *TiledDepthMap) Stats() (int, int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.lru.Len(), t.processed
+}
+
+func (t *TiledDepthMap) keyOf(lon, lat float32) tileKey {
+	x := math.Mod(float64(lon)+180, 360)
+	if x < 0 {
+		x += 360
+	}
+	ti := min(t.nTi-1, int(x/float64(t.size)))
+	tj := min(t.nTj-1, max(0, int(math.Floor(float64((lat+90)/t.size)))))
+	return tileKey{ti, tj}
+}
+
+// same as keyOf for a point of the raw grid but exact at the tile borders
+func (t *TiledDepthMap) keyOfIdx(iLon, iLat int) tileKey {
+	g := &t.raw.grid
+	n_lon := int(math.Round(float64(t.size / g.DLon)))
+	n_lat := int(math.Round(float64(t.size / g.DLat)))
+	x := (iLon + int(math.Round(float64((g.Lon0+180)/g.DLon)))) % g.NLon
+	y := iLat + int(math.Round(float64((g.Lat0+90)/g.DLat)))
+	return tileKey{min(t.nTi-1, x/n_lon), min(t.nTj-1, max(0, y/n_lat))}
+}
+
+// tile plus margin, cut at the poles
+func (t *TiledDepthMap) tileGrid(key tileKey) Grid {
+	g := &t.raw.grid
+	m_lon, m_lat := float32(t.margin)*g.DLon, float32(t.margin)*g.DLat
+	lon0 := -180 + float32(key.ti)*t.size - m_lon
+	lat0 := max(-90, -90+float32(key.tj)*t.size-m_lat)
+	lat1 := min(90, -90+float32(key.tj+1)*t.size+m_lat)
+	n_lon := int(math.Round(float64(t.size/g.DLon))) + 2*t.margin + 1
+	n_lat := int(math.Round(float64((lat1-lat0)/g.DLat))) + 1
+	return NewGrid(lon0, lat0, g.DLon, g.DLat, n_lon, n_lat)
+}
+
+// run the pipeline on a tile, on failure the raw data is used
+func (t *TiledDepthMap) process(key tileKey) *tile {
+	start := time.Now()
+	grid := t.tileGrid(key)
+	di, dj, _ := grid.offsetIn(&t.raw.grid)
+
+	in := newDepthMap(t.raw.Logger, t.raw.name, grid)
+	in.interp = t.raw.interp
+	for i := 0; i < grid.NLon; i++ {
+		for j := 0; j < grid.NLat; j++ {
+			in.set(i, j, t.raw.GetIdx(i+di, j+dj))
+		}
+	}
+
+	pc := t.pc
+	out, err := t.pipeline.Run(&pc, in)
+	if err != nil {
+		t.Logger.Errorf("Tile (%d, %d): %v, using raw data", key.ti, key.tj, err)
+		out = in
+	}
+
+	out.interp = t.interp
+	t.lock.Lock()
+	t.processed++
+	t.lock.Unlock()
+
+	lon, lat := grid.LonLat(0, 0)
+	t.Logger.Infof("Tile (%d, %d) at (%0.1f, %0.1f) processed in %v", key.ti, key.tj,
+		lon+float32(t.margin)*grid.DLon, lat, time.Since(start).Round(time.Millisecond))
+	return &tile{key: key, di: di, dj: dj, dm: out}
+}
+
+// cached tile or nil, a missing tile is queued for the prefetch goroutine
+func (t *TiledDepthMap) cached(key tileKey) *tile {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if e, ok := t.tiles[key]; ok {
+		t.lru.MoveToFront(e)
+		return e.Value.(*tile)
+	}
+	t.enqueue(key)
+	t.startPrefetch()
+	return nil
+}
+
+// cached or freshly processed tile, only for the prefetch goroutine
+func (t *TiledDepthMap) tile(key tileKey) *tile {
+	t.lock.Lock()
+	if e, ok := t.tiles[key]; ok {
+		t.lru.MoveToFront(e)
+		t.lock.Unlock()
+		return e.Value.(*tile)
+	}
+	t.lock.Unlock()
+
+	// processing is not under the lock, rarely a tile may be processed twice
+	tl := t.process(key)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if e, ok := t.tiles[key]; ok {
+		t.lru.MoveToFront(e)
+		return e.Value.(*tile)
+	}
+	t.tiles[key] = t.lru.PushFront(tl)
+	for t.lru.Len() > t.maxTiles {
+		e := t.lru.Back()
+		t.lru.Remove(e)
+		delete(t.tiles, e.Value.(*tile).key)
+	}
+	return tl
+}
+
+// tiles intersecting the box around (lat, lon) with radius r, appended to keys
+func (t *TiledDepthMap) tilesAround(keys []tileKey, seen map[tileKey]bool, lat, lon, r float32) []tileKey {
+	dlat := r / lat2m
+	cl := float32(math.Cos(float64(min(89, abs32(lat)+dlat)) * math.Pi / 180))
+	dlon := min(180, dlat/cl)
+
+	sw := t.keyOf(lon-dlon, lat-dlat)
+	ne := t.keyOf(lon+dlon, lat+dlat)
+	if dlon >= 180 {
+		sw.ti, ne.ti = 0, t.nTi-1
+	}
+	for tj := sw.tj; tj <= ne.tj; tj++ {
+		for ti := sw.ti; ; ti = (ti + 1) % t.nTi {
+			k := tileKey{ti, tj}
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+			if ti == ne.ti {
+				break
+			}
+		}
+	}
+	return keys
+}
+
+// tiles needed at the position and ahead on the track, nearest first, at most maxTiles
+func (t *TiledDepthMap) wanted(lat, lon, track float32) []tileKey {
+	seen := make(map[tileKey]bool)
+	keys := t.tilesAround(nil, seen, lat, lon, t.radius)
+
+	// look ahead 2 radii on the track
+	d := 2 * t.radius / lat2m
+	trk := float64(track) * math.Pi / 180
+	lat_a := min(89.9, max(-89.9, lat+d*float32(math.Cos(trk))))
+	lon_a := lon + d*float32(math.Sin(trk))/float32(math.Cos(float64(lat)*math.Pi/180))
+	keys = t.tilesAround(keys, seen, lat_a, lon_a, t.radius)
+
+	if len(keys) > t.maxTiles {
+		keys = keys[:t.maxTiles]
+	}
+	return keys
+}
+
+// Make sure the tiles around the aircraft and ahead on its track (in ° true) are ready.
+// Does not block, missing tiles are processed in the background.
+func (t *TiledDepthMap) Track(lat, lon, track float32) {
+	keys := t.wanted(lat, lon, track)
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// touch in reverse order so the nearest end up most recently used
+	for k := len(keys) - 1; k >= 0; k-- {
+		key := keys[k]
+		if e, ok := t.tiles[key]; ok {
+			t.lru.MoveToFront(e)
+		} else {
+			t.enqueue(key)
+		}
+	}
+	t.startPrefetch()
+}
+
+// the last one queued is processed first, t.lock must be held
+func (t *TiledDepthMap) enqueue(key tileKey) {
+	if !t.pending[key] {
+		t.pending[key] = true
+		t.queue = append(t.queue, key)
+	}
+}
+
+// t.lock must be held
+func (t *TiledDepthMap) startPrefetch() {
+	if len(t.queue) > 0 && !t.running {
+		t.running = true
+		go t.prefetch()
+	}
+}
+
+// runs until the queue is empty
+func (t *TiledDepthMap) prefetch() {
+	for {
+		t.lock.Lock()
+		if len(t.queue) == 0 {
+			t.running = false
+			t.lock.Unlock()
+			return
+		}
+		key := t.queue[len(t.queue)-1] // nearest was queued last
+		t.queue = t.queue[:len(t.queue)-1]
+		t.lock.Unlock()
+
+		t.tile(key)
+
+		t.lock.Lock()
+		delete(t.pending, key)
+		t.lock.Unlock()
+	}
+}
+
+// the whole map on the raw map's grid, processed tile by tile without disturbing the cache
+func (t *TiledDepthMap) Materialize() *depthMap {
+	out := derivedMap(t.raw, t.name)
+	g := &t.raw.grid
+
+	for ti := 0; ti < t.nTi; ti++ {
+		for tj := 0; tj < t.nTj; tj++ {
+			key := tileKey{ti, tj}
+			t.lock.Lock()
+			e, ok := t.tiles[key]
+			t.lock.Unlock()
+
+			var tl *tile
+			if ok {
+				tl = e.Value.(*tile)
+			} else {
+				tl = t.process(key)
+			}
+
+			tg := &tl.dm.grid
+			for i := 0; i < tg.NLon; i++ {
+				for j := 0; j < tg.NLat; j++ {
+					// each grid point belongs to exactly one tile, the margin is skipped
+					x, y, _ := g.wrap(i+tl.di, j+tl.dj)
+					if t.keyOfIdx(x, y) == key {
+						out.set(x, y, tl.dm.at(i, j))
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// for consumers that work on the whole world
+func Materialize(dm DepthMap) DepthMap {
+	if t, ok := dm.(*TiledDepthMap); ok {
+		return t.Materialize()
+	}
+	return dm
+}
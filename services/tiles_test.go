@@ -0,0 +1,178 @@
+package services
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/xairline/xa-snow/utils/coast"
+	"sync"
+	"testing"
+	"time"
+)
+
+// water in stripes, the coast looks east
+type stubCoast struct{}
+
+func (stubCoast) IsWater(i, j int) bool {
+	i %= n_iLon
+	if i < 0 {
+		i += n_iLon
+	}
+	return i%100 < 30
+}
+
+func (c stubCoast) IsLand(i, j int) bool {
+	return !c.IsWater(i, j)
+}
+
+func (c stubCoast) IsCoast(i, j int) (bool, int, int, int) {
+	if c.IsWater(i, j) && !c.IsWater(i+1, j) {
+		return true, 1, 0, 0
+	}
+	return false, 0, 0, 0
+}
+
+var stubCoastPoints = sync.OnceValue(func() []coast.Point {
+	return scanCoastPoints(stubCoast{})
+})
+
+func (stubCoast) CoastPoints() []coast.Point {
+	return stubCoastPoints()
+}
+
+// coast points of a stub from IsCoast
+func scanCoastPoints(cs CoastService) []coast.Point {
+	var points []coast.Point
+	for i := 0; i < n_iLon; i++ {
+		for j := 0; j < n_iLat-1; j++ {
+			if yes, dx, dy, _ := cs.IsCoast(i, j); yes {
+				points = append(points, coast.Point{I: int32(i), J: int32(j), DirX: int8(dx), DirY: int8(dy)})
+			}
+		}
+	}
+	return points
+}
+
+func stripedSnow() *depthMap {
+	m := newDepthMap(newMockLogger(), "Snow", GlobalGrid)
+	for i := 0; i < GlobalGrid.NLon; i++ {
+		for j := 0; j < GlobalGrid.NLat; j++ {
+			if i%100 >= 32 {
+				m.set(i, j, float32((i*7+j*13)%50)*0.002)
+			}
+		}
+	}
+	return m
+}
+
+// value once the background processing is done, the first call may just queue the tile
+func settled(t *testing.T, tiled *TiledDepthMap, get func() float32) float32 {
+	get()
+	assert.Eventually(t, func() bool {
+		tiled.lock.Lock()
+		defer tiled.lock.Unlock()
+		return !tiled.running
+	}, 5*time.Second, time.Millisecond)
+	return get()
+}
+
+func TestTiledMatchesGlobal(t *testing.T) {
+	raw := stripedSnow()
+	pc := &PipelineContext{Logger: newMockLogger(), Cs: stubCoast{}, Maps: map[string]DepthMap{"raw": raw}}
+	p, err := ParsePipeline("coast,blur:2,mask:land,threshold:0.01")
+	assert.NoError(t, err)
+
+	global, err := p.Run(pc, raw)
+	assert.NoError(t, err)
+
+	tiled, err := NewTiledDepthMap(pc, p, raw, 10, 300000, 4, raw.interp)
+	assert.NoError(t, err)
+
+	// not processed yet: the raw data, the tile is queued
+	assert.NotEqual(t, global.GetIdx(33, 100), raw.GetIdx(33, 100))
+	assert.Equal(t, raw.GetIdx(33, 100), tiled.GetIdx(33, 100))
+
+	// points on tile borders, the antimeridian and the poles
+	for _, ij := range [][2]int{{0, 900}, {1800, 900}, {1799, 900}, {1900, 1000}, {1901, 999},
+		{33, 100}, {3599, 0}, {10, 1800}, {1234, 567}} {
+		v := settled(t, tiled, func() float32 { return tiled.GetIdx(ij[0], ij[1]) })
+		assert.Equal(t, global.GetIdx(ij[0], ij[1]), v, "%v", ij)
+	}
+	for _, ll := range [][2]float32{{-180, 0}, {-170.05, 10}, {9.33, 51.41}, {179.97, -60.02}, {3.0, 89.95}} {
+		v := settled(t, tiled, func() float32 { return tiled.Get(ll[0], ll[1]) })
+		assert.InDelta(t, global.Get(ll[0], ll[1]), v, 1e-5, "%v", ll) // float32 index arithmetic differs
+	}
+
+	// the cache is bounded
+	n, _ := tiled.Stats()
+	assert.LessOrEqual(t, n, 4)
+
+	m := tiled.Materialize()
+	for i := 0; i < GlobalGrid.NLon; i += 7 {
+		for j := 0; j < GlobalGrid.NLat; j += 3 {
+			if global.at(i, j) != m.at(i, j) {
+				t.Fatalf("(%d, %d): %f != %f", i, j, global.at(i, j), m.at(i, j))
+			}
+		}
+	}
+}
+
+func TestTilePrefetch(t *testing.T) {
+	raw := stripedSnow()
+	pc := &PipelineContext{Logger: newMockLogger(), Cs: stubCoast{}, Maps: map[string]DepthMap{"raw": raw}}
+	p, _ := ParsePipeline("coast")
+	tiled, err := NewTiledDepthMap(pc, p, raw, 10, 100000, 8, raw.interp)
+	assert.NoError(t, err)
+
+	// heading north in the middle of a tile, 100 km around and 200 km ahead stay within it
+	keys := tiled.wanted(45, 5, 0)
+	assert.Equal(t, []tileKey{{18, 13}}, keys)
+
+	// EDDF is close to the tile corner at 50°N 10°E
+	keys = tiled.wanted(50.03, 8.57, 0)
+	assert.Equal(t, []tileKey{{18, 13}, {18, 14}, {19, 14}}, keys)
+
+	// near the antimeridian and a tile corner
+	keys = tiled.wanted(0.5, 179.5, 90)
+	assert.Contains(t, keys, tileKey{35, 9})
+	assert.Contains(t, keys, tileKey{35, 8})
+	assert.Contains(t, keys, tileKey{0, 9})
+
+	tiled.Track(50.03, 8.57, 0)
+	assert.Eventually(t, func() bool {
+		tiled.lock.Lock()
+		defer tiled.lock.Unlock()
+		return len(tiled.tiles) == 3 && !tiled.running
+	}, 5*time.Second, time.Millisecond)
+	_, processed := tiled.Stats()
+	assert.Equal(t, 3, processed)
+
+	// no reprocessing once cached
+	tiled.Get(8.57, 50.03)
+	tiled.Track(50.03, 8.57, 0)
+	_, processed = tiled.Stats()
+	assert.Equal(t, 3, processed)
+
+	_, err = NewTiledDepthMap(pc, p, raw, 7, 100000, 8, raw.interp)
+	assert.Error(t, err)
+	_, err = NewTiledDepthMap(pc, p, rampMap("ramp"), 10, 100000, 8, raw.interp)
+	assert.Error(t, err)
+}
+
+func TestTileMargin(t *testing.T) {
+	raw := stripedSnow()
+	pc := &PipelineContext{Logger: newMockLogger(), Cs: stubCoast{}, Maps: map[string]DepthMap{"raw": raw}}
+	p, _ := ParsePipeline("coast,gauss:4")
+	tiled, err := NewTiledDepthMap(pc, p, raw, 10, 100000, 8, raw.interp)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*3+10+interpReach, tiled.margin)
+	g := tiled.tileGrid(tileKey{18, 9})
+	assert.Equal(t, 100+2*tiled.margin+1, g.NLon)
+	assert.InDelta(t, -float32(tiled.margin)*0.1, g.Lon0, 1e-4)
+
+	// the coast extension reaches 40 grid points, more than a 2° tile
+	pc.Coast = CoastParams{Name: "wide", MinSd: 0.02, MaxStep: 20, Decay: 0.9}
+	_, err = NewTiledDepthMap(pc, p, raw, 2, 100000, 8, raw.interp)
+	assert.Error(t, err)
+	tiled, err = NewTiledDepthMap(pc, p, raw, 10, 100000, 8, raw.interp)
+	assert.NoError(t, err)
+	assert.Equal(t, 2*20+10+interpReach, tiled.margin)
+}
@@ -26,22 +26,31 @@
 package services
 
 import (
+	"fmt"
+	"github.com/xairline/xa-snow/utils/coast"
 	"github.com/xairline/xa-snow/utils/logger"
-    "unsafe"
+	"path/filepath"
+	"sync"
+	"unsafe"
 )
 
 // #include "xa-snow-cgo.h"
 // #include <stdlib.h>
 import "C"
 
-type CoastService interface {
-	IsWater(i, j int) bool
-	IsLand(i, j int) bool
-	IsCoast(i, j int) (bool, int, int, int)	// -> yes_no, dir_x, dir_y, grid angle
-}
-
 type coastService struct {
 	logger	logger.Logger
+	once	sync.Once
+	cm	*coast.Map
+}
+
+// Go copy of the C++ map, made on first use, the coastal extension would do
+// cgo calls per grid point otherwise
+func (cs *coastService) coastMap() *coast.Map {
+    cs.once.Do(func() {
+        cs.cm = coast.From(cs, n_iLon, n_iLat-1, coast.DefaultOffset)
+    })
+    return cs.cm
 }
 
 func (cs *coastService)IsWater(i, j int) bool {
@@ -57,17 +66,21 @@ func (cs *coastService)IsCoast(i, j int) (bool, int, int, int) {
     return bool(res.yes_no), int(res.dir_x), int(res.dir_y), int(res.grid_angle)
 }
 
-func NewCoastService(logger logger.Logger, dir string) CoastService {
-	cs := &coastService{logger:logger}
+func (cs *coastService)CoastPoints() []coast.Point {
+    return cs.coastMap().CoastPoints()
+}
 
-    var cdir *C.char = C.CString(dir)
-    defer C.free(unsafe.Pointer(cdir))
+// the C++ version of NewCoastService, there is only one C++ coast map
+func NewCgoCoastService(logger logger.Logger, dir string) (CoastService, error) {
+	cs := &coastService{logger: logger}
 
-    if bool(C.CoastMapInit(cdir)) {
-        return cs
-    }
+	var cdir *C.char = C.CString(dir)
+	defer C.free(unsafe.Pointer(cdir))
 
-    return nil;
+	if bool(C.CoastMapInit(cdir)) {
+		return cs, nil
+	}
+	return nil, fmt.Errorf("can't load coast map '%s'", filepath.Join(dir, coast.FileName))
 }
 
 func SnowDepthToXplaneSnowNow(depth float32) (float32, float32, float32) {
@@ -42,7 +42,7 @@ type xplaneService struct {
 	GribService GribService
 	drefsInited bool
 
-	lat_dr, lon_dr,
+	lat_dr, lon_dr, track_dr,
 	weatherMode_dr,
 	sysTime_dr, simCurrentDay_dr, simCurrentMonth_dr, simLocalHours_dr,
 	snow_dr, ice_dr,
@@ -113,13 +113,17 @@ func NewXplaneService(
 
 		systemPath := utilities.GetSystemPath()
 		pluginPath := filepath.Join(systemPath, "Resources", "plugins", "XA-snow")
+		cs, err := NewCoastService(logger, pluginPath)
+		if err != nil {
+			logger.Errorf("No coastal snow: %v", err)
+		}
 		_, cancelFunc := context.WithCancel(context.Background())
 		xplaneSvc := &xplaneService{
 			Plugin: extra.NewPlugin("X Airline Snow - "+VERSION, "com.github.xairline.xa-snow", "show accumulated snow in X-Plane's world"),
 			GribService: NewGribService(logger,
 				path.Join(systemPath, "Output", "snow"),
 				filepath.Join(pluginPath, "bin"),
-				NewCoastService(logger, pluginPath)),
+				cs),
 			Logger:     logger,
 			disabled:   false,
 			override:   false,
@@ -192,6 +196,7 @@ func (s *xplaneService) onPluginStart() {
 	// API drefs are available at plugin start
 	s.lat_dr, _ = dataAccess.FindDataRef("sim/flightmodel/position/latitude")
 	s.lon_dr, _ = dataAccess.FindDataRef("sim/flightmodel/position/longitude")
+	s.track_dr, _ = dataAccess.FindDataRef("sim/flightmodel/position/hpath")
 	s.weatherMode_dr, _ = dataAccess.FindDataRef("sim/weather/region/weather_source")
 	s.sysTime_dr, _ = dataAccess.FindDataRef("sim/time/use_system_time")
 	s.simCurrentMonth_dr, _ = dataAccess.FindDataRef("sim/cockpit2/clock_timer/current_month")
@@ -210,6 +215,10 @@ func (s *xplaneService) onPluginStart() {
 	s.myMenuItemIndex3 = menus.AppendMenuItem(s.myMenuId, "Enable Historical Snow", 2, true)
 	s.myMenuItemIndex4 = menus.AppendMenuItem(s.myMenuId, "Enable Snow Depth Auto Update", 3, true)
     s.myMenuItemIndex5 = menus.AppendMenuItem(s.myMenuId, "Limit snow for legacy airports", 4, true)
+	menus.AppendMenuSeparator(s.myMenuId)
+	menus.AppendMenuItem(s.myMenuId, "Show Historical Snow Availability", 5, false)
+	menus.AppendMenuItem(s.myMenuId, "Export Snow Map", 6, false)
+	menus.AppendMenuItem(s.myMenuId, "Export Snow Diff", 7, false)
 
 	if s.override {
 		menus.CheckMenuItem(s.myMenuId, s.myMenuItemIndex, menus.Menu_Checked)
@@ -317,6 +326,7 @@ func (s *xplaneService) flightLoop(
 	if s.loopCnt%8 == 0 {
 		lat := dataAccess.GetFloatData(s.lat_dr)
 		lon := dataAccess.GetFloatData(s.lon_dr)
+		s.GribService.Track(lat, lon, dataAccess.GetFloatData(s.track_dr))
 		snowDepth_n := s.GribService.GetSnowDepth(lat, lon)
         if s.limitSnow {
             snowDepth_n = float32(C.LegacyAirportSnowDepth(C.float(snowDepth_n)))
@@ -365,20 +375,42 @@ func (s *xplaneService) messageHandler(message plugins.Message) {
 }
 
 func (s *xplaneService) writeConfig() {
+	// keep settings that are not controlled by the menu, e.g. ARCHIVE_MANIFEST
+	config, err := godotenv.Read(s.configFilePath)
+	if err != nil {
+		config = map[string]string{}
+	}
+
+	config["OVERRIDE"] = strconv.FormatBool(s.override)
+	config["RWY_ICE"] = strconv.FormatBool(s.rwyIce)
+	config["HISTORICAL"] = strconv.FormatBool(s.historical)
+	config["AUTOUPDATE"] = strconv.FormatBool(s.autoUpdate)
+	config["LIMIT_SNOW"] = strconv.FormatBool(s.limitSnow)
+
 	// write to config
-	err := godotenv.Write(map[string]string{
-		"OVERRIDE":   strconv.FormatBool(s.override),
-		"RWY_ICE":    strconv.FormatBool(s.rwyIce),
-		"HISTORICAL": strconv.FormatBool(s.historical),
-		"AUTOUPDATE": strconv.FormatBool(s.autoUpdate),
-        "LIMIT_SNOW": strconv.FormatBool(s.limitSnow),
-	}, s.configFilePath)
+	err = godotenv.Write(config, s.configFilePath)
 	if err != nil {
 		s.Logger.Errorf("Error writing to config: %v", err)
 	}
 }
 
 
+func (s *xplaneService) showArchiveAvailability() {
+	m, err := s.GribService.GetArchiveManifest()
+	if err != nil {
+		s.Logger.Errorf("Historical snow availability unknown: %v", err)
+		return
+	}
+	if m == nil {
+		s.Logger.Warning("No archive manifest configured, set ARCHIVE_MANIFEST in xa-snow.prf")
+		return
+	}
+
+	for _, l := range m.Describe() {
+		s.Logger.Info(l)
+	}
+}
+
 func (s *xplaneService) menuHandler(menuRef interface{}, itemRef interface{}) {
 	if itemRef.(int) == 0 {
 		s.override = !s.override
@@ -433,5 +465,35 @@ func (s *xplaneService) menuHandler(menuRef interface{}, itemRef interface{}) {
 		s.Logger.Infof("LIMIT_SNOW: %v", s.limitSnow)
 	}
 
+	if itemRef.(int) == 5 {
+		// may be a download from a mirror so don't block the sim
+		go s.showArchiveAvailability()
+		return
+	}
+
+	if itemRef.(int) == 6 || itemRef.(int) == 7 {
+		dir := filepath.Join(utilities.GetSystemPath(), "Output", "snow")
+		diff := itemRef.(int) == 7
+		go func() {
+			os.MkdirAll(dir, os.ModePerm)
+			if !diff {
+				if _, err := s.GribService.ExportSnow(dir); err != nil {
+					s.Logger.Errorf("Export of snow map failed: %v", err)
+				}
+				return
+			}
+
+			// DIFF_BASE: raw, previous or yyyymmddhh
+			base := os.Getenv("DIFF_BASE")
+			if base == "" {
+				base = "previous"
+			}
+			if _, err := s.GribService.ExportDiff(dir, base); err != nil {
+				s.Logger.Errorf("Export of snow diff failed: %v", err)
+			}
+		}()
+		return
+	}
+
     s.writeConfig()
 }
@@ -0,0 +1,121 @@
+package coast
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// The classified map is kept next to the PNG so later starts don't have to decode and classify
+// the mask again. The cache is keyed on the checksum of the PNG and the offset, a new PNG or a
+// different classification (cacheVersion) invalidates it.
+const (
+	CacheName    = "ESACCI-LC-L4-WB-Ocean-Map-150m-P13Y-2000-v4.0.wmap"
+	cacheVersion = 1
+)
+
+var cacheMagic = [8]byte{'X', 'A', 'C', 'O', 'A', 'S', 'T', 0}
+
+type cacheHeader struct {
+	Magic   [8]byte
+	Version uint32
+	Crc     uint32 // of the PNG
+	Size    int64  // of the PNG
+	Offset  int32
+	N, M    int32
+}
+
+func readCache(path string, key cacheHeader) (*Map, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var hdr cacheHeader
+	if err := binary.Read(f, binary.LittleEndian, &hdr); err != nil {
+		return nil, err
+	}
+	if hdr != key {
+		return nil, fmt.Errorf("'%s' is outdated", path)
+	}
+
+	cm := &Map{n: int(hdr.N), m: int(hdr.M), offset: int(hdr.Offset), wmap: make([]uint8, int(hdr.N)*int(hdr.M))}
+	zr := flate.NewReader(f)
+	if _, err := io.ReadFull(zr, cm.wmap); err != nil {
+		return nil, fmt.Errorf("'%s': %w", path, err)
+	}
+	cm.indexPoints()
+	return cm, nil
+}
+
+func writeCache(path string, hdr cacheHeader, cm *Map) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = binary.Write(f, binary.LittleEndian, &hdr)
+	if err == nil {
+		zw, _ := flate.NewWriter(f, flate.BestSpeed)
+		if _, err = zw.Write(cm.wmap); err == nil {
+			err = zw.Close()
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Like LoadDir, but from the cache if it is up to date. A cache that can't be written is not an
+// error, the mask is just classified again on the next start.
+func LoadDirCached(dir string, offset int) (cm *Map, cached bool, err error) {
+	return LoadCached(filepath.Join(dir, FileName), filepath.Join(dir, CacheName), offset)
+}
+
+// Like Load with the classified map cached in the file cache.
+func LoadCached(path, cache string, offset int) (cm *Map, cached bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := cacheHeader{Magic: cacheMagic, Version: cacheVersion, Crc: crc32.ChecksumIEEE(data),
+		Size: int64(len(data)), Offset: int32(offset)}
+
+	// size of the image from the PNG header without decoding it
+	cfg, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("'%s': %w", path, err)
+	}
+	key.N, key.M = int32(cfg.Width), int32(cfg.Height)
+
+	if cm, err := readCache(cache, key); err == nil {
+		return cm, true, nil
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, fmt.Errorf("'%s': %w", path, err)
+	}
+	cm, err = New(img, offset)
+	if err != nil {
+		return nil, false, fmt.Errorf("'%s': %w", path, err)
+	}
+	writeCache(cache, key, cm)
+	return cm, false, nil
+}
@@ -0,0 +1,89 @@
+package coast
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeMask(t *testing.T, dir string, land func(x, y int) bool) {
+	f, err := os.Create(filepath.Join(dir, FileName))
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(f, mask(80, 40, land)))
+	f.Close()
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	_, _, err := LoadDirCached(dir, 0)
+	assert.Error(t, err)
+
+	writeMask(t, dir, func(x, y int) bool { return x >= 40 })
+	cm, cached, err := LoadDirCached(dir, 0)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	_, err = os.Stat(filepath.Join(dir, CacheName))
+	assert.NoError(t, err)
+
+	cm2, cached, err := LoadDirCached(dir, 0)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, cm, cm2)
+
+	// another offset
+	_, cached, err = LoadDirCached(dir, 2)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	_, cached, _ = LoadDirCached(dir, 2)
+	assert.True(t, cached)
+
+	// the mask changed
+	writeMask(t, dir, func(x, y int) bool { return x >= 50 })
+	cm, cached, err = LoadDirCached(dir, 2)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.True(t, cm.IsWater(45-2-40, 20))
+
+	// broken cache
+	data, _ := os.ReadFile(filepath.Join(dir, CacheName))
+	os.WriteFile(filepath.Join(dir, CacheName), data[:len(data)/2], 0644)
+	cm2, cached, err = LoadDirCached(dir, 2)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, cm, cm2)
+
+	// other version
+	data, _ = os.ReadFile(filepath.Join(dir, CacheName))
+	data[8]++
+	os.WriteFile(filepath.Join(dir, CacheName), data, 0644)
+	_, cached, _ = LoadDirCached(dir, 2)
+	assert.False(t, cached)
+
+	// read only: still works
+	os.Remove(filepath.Join(dir, CacheName))
+	os.Mkdir(filepath.Join(dir, CacheName), 0755)
+	cm2, cached, err = LoadDirCached(dir, 2)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, cm, cm2)
+}
+
+func TestCacheOtherMask(t *testing.T) {
+	dir := t.TempDir()
+	writeMask(t, dir, func(x, y int) bool { return x < 20 || x >= 30 })
+	path, cache := filepath.Join(dir, FileName), filepath.Join(dir, "lakes.wmap")
+
+	cm, cached, err := LoadCached(path, cache, 1)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, 1, cm.Offset())
+	_, err = os.Stat(filepath.Join(dir, CacheName))
+	assert.True(t, os.IsNotExist(err))
+
+	cm2, cached, err := LoadCached(path, cache, 1)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, cm, cm2)
+}
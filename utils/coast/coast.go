@@ -0,0 +1,311 @@
+// Package coast classifies the points of a global water mask into water, land and coast with the
+// direction towards land. It's the pure Go version of coast.cpp and does not need cgo.
+package coast
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// ESA CCI water bodies in 0.1° resolution, black is water
+const FileName = "ESACCI-LC-L4-WB-Ocean-Map-150m-P13Y-2000-v4.0.png"
+
+const (
+	// the mask is shifted by this many points against the snow grid, determined by visual adjustment
+	// could be one system is at point, the other at center of grid
+	DefaultOffset = 3
+	poleMargin    = 10 // rows not classified at each pole
+)
+
+// we use a "grid direction" = 360°/45° in standard math convention
+// 0 -> x, 2 -> y, 4 -> -x, ...
+var dirX = [8]int{1, 1, 0, -1, -1, -1, 0, 1}
+var dirY = [8]int{0, 1, 1, 1, 0, -1, -1, -1}
+
+const (
+	sWater = iota
+	sLand
+	sCoast
+)
+
+// Classified mask, point (0, 0) is at 0°/-90° like the snow grid, i = 0 .. n-1 eastwards and
+// j = 0 .. m-1 northwards.
+type Map struct {
+	n, m   int
+	offset int
+	wmap   []uint8 // [i * m + j], encoded as (dir << 2) | sXxx
+	points []Point // coast points in the order of wmap
+}
+
+// a coast point and the direction towards land
+type Point struct {
+	I, J       int32
+	DirX, DirY int8
+}
+
+func (cm *Map) Size() (int, int) {
+	return cm.n, cm.m
+}
+
+// shift against the snow grid the mask was classified with
+func (cm *Map) Offset() int {
+	return cm.offset
+}
+
+// lon wraps, lat is clamped
+func (cm *Map) wrap(i, j int) int {
+	i %= cm.n
+	if i < 0 {
+		i += cm.n
+	}
+	j = min(cm.m-1, max(0, j))
+	return i*cm.m + j
+}
+
+func (cm *Map) IsWater(i, j int) bool {
+	return cm.wmap[cm.wrap(i, j)]&0x3 == sWater
+}
+
+func (cm *Map) IsLand(i, j int) bool {
+	return cm.wmap[cm.wrap(i, j)]&0x3 == sLand
+}
+
+// -> yes_no, dir_x, dir_y, grid angle, points beyond the poles are no coast
+func (cm *Map) IsCoast(i, j int) (bool, int, int, int) {
+	if j < 0 || j >= cm.m {
+		return false, 0, 0, 0
+	}
+	v := cm.wmap[cm.wrap(i, j)]
+	dir := int(v >> 2)
+	return v&0x3 == sCoast, dirX[dir], dirY[dir], dir
+}
+
+// all coast points sorted by i, then j, not to be modified
+func (cm *Map) CoastPoints() []Point {
+	return cm.points
+}
+
+func (cm *Map) indexPoints() {
+	cm.points = nil
+	for k, v := range cm.wmap {
+		if v&0x3 == sCoast {
+			dir := v >> 2
+			cm.points = append(cm.points, Point{I: int32(k / cm.m), J: int32(k % cm.m),
+				DirX: int8(dirX[dir]), DirY: int8(dirY[dir])})
+		}
+	}
+}
+
+// number of points per class
+func (cm *Map) Count() (water, land, coast int) {
+	for _, v := range cm.wmap {
+		switch v & 0x3 {
+		case sWater:
+			water++
+		case sLand:
+			land++
+		default:
+			coast++
+		}
+	}
+	return
+}
+
+// water bitmap of an image, row 0 at the top
+func waterPixels(img image.Image) []bool {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	water := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var black bool
+			switch im := img.(type) {
+			case *image.NRGBA:
+				p := im.Pix[im.PixOffset(b.Min.X+x, b.Min.Y+y):]
+				black = p[0] == 0 && p[1] == 0 && p[2] == 0
+			case *image.RGBA:
+				p := im.Pix[im.PixOffset(b.Min.X+x, b.Min.Y+y):]
+				black = p[0] == 0 && p[1] == 0 && p[2] == 0
+			case *image.Gray:
+				black = im.GrayAt(b.Min.X+x, b.Min.Y+y).Y == 0
+			default:
+				// not the alpha channel, the C version uses 8 bit channels
+				r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+				black = r>>8 == 0 && g>>8 == 0 && bl>>8 == 0
+			}
+			water[y*w+x] = black
+		}
+	}
+	return water
+}
+
+// Classify a water mask with the world in plate carrée, column 0 at 180°W and row 0 at 90°N.
+// offset shifts the mask against the snow grid, the default is DefaultOffset.
+func New(img image.Image, offset int) (*Map, error) {
+	b := img.Bounds()
+	n, m := b.Dx(), b.Dy()
+	if n < 2 || n%2 != 0 || m <= 2*poleMargin {
+		return nil, fmt.Errorf("invalid water mask size %dx%d", n, m)
+	}
+	if offset < 0 || offset > poleMargin {
+		return nil, fmt.Errorf("invalid offset %d", offset)
+	}
+
+	pix := waterPixels(img)
+	is_water := func(i, j int) bool {
+		j = m - j // for the image (0,0) is top left to flip y values
+		i %= n
+		if i < 0 {
+			i += n
+		}
+		j = min(m-1, max(0, j))
+		return pix[j*n+i]
+	}
+
+	cm := &Map{n: n, m: m, offset: offset, wmap: make([]uint8, n*m)}
+	for i := 0; i < n; i++ {
+		for j := poleMargin; j < m-poleMargin; j++ { // stay away from the poles
+			i_cs := i - offset - n/2
+			if i_cs < 0 {
+				i_cs += n
+			}
+			j_cs := j - offset
+			k := i_cs*m + j_cs
+
+			if !is_water(i, j) {
+				cm.wmap[k] = sLand
+				continue
+			}
+
+			cm.wmap[k] = classifyWater(is_water, i, j)
+		}
+	}
+	cm.indexPoints()
+	return cm, nil
+}
+
+// class of water point (i, j), water or coast with the direction towards land
+func classifyWater(is_water func(i, j int) bool, i, j int) uint8 {
+	// we check whether to the opposite side is only water and in direction 'dir' is land
+	// if yes we sum up all unity vectors in dir to get the 'average' direction
+	sum_x, sum_y := float32(0), float32(0)
+	is_coast := false
+	for dir := 0; dir < 8; dir++ {
+		di, dj := dirX[dir], dirY[dir]
+		if is_water(i-2*di, j-2*dj) && is_water(i-di, j-dj) && !is_water(i+di, j+dj) {
+			f := float32(1)
+			if dir&1 != 0 {
+				f = 0.7071 // diagonal = 1/sqrt(2)
+			}
+			sum_x += f * float32(di)
+			sum_y += f * float32(dj)
+			is_coast = true
+		}
+	}
+
+	if !is_coast {
+		return sWater
+	}
+
+	// angle of the average direction is the normal of the coast line
+	ang := float32(math.Atan2(float64(sum_y), float64(sum_x)) * 180 / math.Pi)
+	if ang < 0 {
+		ang += 360
+	}
+	dir_land := int(math.Round(float64(ang / 45)))
+	if dir_land == 8 {
+		dir_land = 0
+	}
+	return uint8(dir_land<<2 | sCoast)
+}
+
+// Copy with more land, e.g. frozen sea. Water and coast points where land(i, j) is true become
+// land and the water around them is classified again. Returns cm if nothing changes.
+func (cm *Map) WithLand(land func(i, j int) bool) *Map {
+	var new_land []int
+	for k, v := range cm.wmap {
+		if v&0x3 != sLand && land(k/cm.m, k%cm.m) {
+			new_land = append(new_land, k)
+		}
+	}
+	if len(new_land) == 0 {
+		return cm
+	}
+
+	c := &Map{n: cm.n, m: cm.m, offset: cm.offset, wmap: slices.Clone(cm.wmap)}
+	for _, k := range new_land {
+		c.wmap[k] = sLand
+	}
+
+	// a point's class depends on points up to 2 steps away, rows near the poles are not classified
+	is_water := func(i, j int) bool {
+		return c.wmap[c.wrap(i, j)]&0x3 != sLand
+	}
+	j_min, j_max := poleMargin-c.offset, c.m-poleMargin-c.offset
+	for _, k := range new_land {
+		i0, j0 := k/c.m, k%c.m
+		for i := i0 - 2; i <= i0+2; i++ {
+			for j := max(j0-2, j_min); j <= min(j0+2, j_max-1); j++ {
+				if kk := c.wrap(i, j); c.wmap[kk]&0x3 != sLand {
+					c.wmap[kk] = classifyWater(is_water, i, j)
+				}
+			}
+		}
+	}
+	c.indexPoints()
+	return c
+}
+
+func Load(path string, offset int) (*Map, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("'%s': %w", path, err)
+	}
+	cm, err := New(img, offset)
+	if err != nil {
+		return nil, fmt.Errorf("'%s': %w", path, err)
+	}
+	return cm, nil
+}
+
+// the ESA mask in dir
+func LoadDir(dir string, offset int) (*Map, error) {
+	return Load(filepath.Join(dir, FileName), offset)
+}
+
+// what From needs of another classification
+type Classifier interface {
+	IsWater(i, j int) bool
+	IsCoast(i, j int) (bool, int, int, int) // -> yes_no, dir_x, dir_y, grid angle
+}
+
+// Copy of another classification of the same n x m grid, e.g. the C++ one, so lookups don't go
+// through it any more.
+func From(c Classifier, n, m, offset int) *Map {
+	cm := &Map{n: n, m: m, offset: offset, wmap: make([]uint8, n*m)}
+	for i := 0; i < n; i++ {
+		for j := 0; j < m; j++ {
+			k := i*m + j
+			if is_coast, _, _, dir := c.IsCoast(i, j); is_coast {
+				cm.wmap[k] = uint8(dir<<2 | sCoast)
+			} else if c.IsWater(i, j) {
+				cm.wmap[k] = sWater
+			} else {
+				cm.wmap[k] = sLand
+			}
+		}
+	}
+	cm.indexPoints()
+	return cm
+}
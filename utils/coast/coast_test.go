@@ -0,0 +1,188 @@
+package coast
+
+import (
+	"github.com/stretchr/testify/assert"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// n x m mask, land where land(x, y) in image coordinates
+func mask(n, m int, land func(x, y int) bool) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, n, m))
+	for y := 0; y < m; y++ {
+		for x := 0; x < n; x++ {
+			if land(x, y) {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	return img
+}
+
+func TestStraightCoast(t *testing.T) {
+	// west half water, east half land, offset 0: x = i + n/2
+	cm, err := New(mask(80, 40, func(x, y int) bool { return x >= 40 }), 0)
+	assert.NoError(t, err)
+
+	assert.True(t, cm.IsLand(0, 20))
+	assert.True(t, cm.IsLand(39, 20))
+	assert.True(t, cm.IsWater(60, 20))
+
+	// land to the east
+	yes, dx, dy, dir := cm.IsCoast(79, 20)
+	assert.True(t, yes)
+	assert.Equal(t, []int{1, 0, 0}, []int{dx, dy, dir})
+	// neither water nor land
+	assert.False(t, cm.IsWater(79, 20))
+	assert.False(t, cm.IsLand(79, 20))
+
+	// land to the west, across the wrap of the image
+	yes, dx, dy, dir = cm.IsCoast(40, 20)
+	assert.True(t, yes)
+	assert.Equal(t, []int{-1, 0, 4}, []int{dx, dy, dir})
+	assert.True(t, cm.IsLand(-80, 20))
+	yes, _, _, _ = cm.IsCoast(120, 20)
+	assert.True(t, yes)
+
+	yes, _, _, _ = cm.IsCoast(78, 20)
+	assert.False(t, yes)
+	yes, _, _, _ = cm.IsCoast(79, 40)
+	assert.False(t, yes)
+
+	// poles are not classified
+	assert.True(t, cm.IsWater(10, 5))
+	assert.True(t, cm.IsWater(10, -5))
+	assert.True(t, cm.IsWater(10, 100))
+
+	water, land, coast := cm.Count()
+	assert.Equal(t, 80*40, water+land+coast)
+	assert.Equal(t, 40*20, land)
+	assert.Equal(t, 2*20, coast)
+
+	// the same as a list, first the coast looking west
+	points := cm.CoastPoints()
+	assert.Equal(t, coast, len(points))
+	assert.Equal(t, Point{I: 40, J: 10, DirX: -1, DirY: 0}, points[0])
+	assert.Equal(t, Point{I: 79, J: 29, DirX: 1, DirY: 0}, points[len(points)-1])
+	for _, p := range points {
+		yes, dx, dy, _ := cm.IsCoast(int(p.I), int(p.J))
+		assert.True(t, yes)
+		assert.Equal(t, []int{int(p.DirX), int(p.DirY)}, []int{dx, dy})
+	}
+}
+
+func TestIsland(t *testing.T) {
+	const n, m = 60, 40
+	cm, err := New(mask(n, m, func(x, y int) bool { return x == 40 && y == 20 }), DefaultOffset)
+	assert.NoError(t, err)
+
+	// image to map
+	i0, j0 := 40-DefaultOffset-n/2, (m-20)-DefaultOffset
+	assert.True(t, cm.IsLand(i0, j0))
+
+	// the 8 neighbours point to the island
+	for dir := 0; dir < 8; dir++ {
+		yes, dx, dy, d := cm.IsCoast(i0-dirX[dir], j0-dirY[dir])
+		assert.True(t, yes)
+		assert.Equal(t, dirX[dir], dx)
+		assert.Equal(t, dirY[dir], dy)
+		assert.Equal(t, dir, d)
+	}
+	yes, _, _, _ := cm.IsCoast(i0-2, j0)
+	assert.False(t, yes)
+
+	_, land, coast := cm.Count()
+	assert.Equal(t, 1, land)
+	assert.Equal(t, 8, coast)
+}
+
+func TestBay(t *testing.T) {
+	// land to the east and to the north: the normal is the average
+	cm, err := New(mask(80, 40, func(x, y int) bool { return x >= 50 || y <= 15 }), 0)
+	assert.NoError(t, err)
+
+	j := 40 - 16 // row 16 is water, 15 land
+	yes, dx, dy, dir := cm.IsCoast(50-40-1, j)
+	assert.True(t, yes)
+	assert.Equal(t, []int{1, 1, 1}, []int{dx, dy, dir})
+
+	yes, dx, dy, _ = cm.IsCoast(30-40+80, j)
+	assert.True(t, yes)
+	assert.Equal(t, []int{0, 1}, []int{dx, dy})
+}
+
+func TestLoad(t *testing.T) {
+	_, err := New(mask(81, 40, func(x, y int) bool { return false }), 0)
+	assert.Error(t, err)
+	_, err = New(mask(80, 20, func(x, y int) bool { return false }), 0)
+	assert.Error(t, err)
+	_, err = New(mask(80, 40, func(x, y int) bool { return false }), -1)
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	_, err = LoadDir(dir, DefaultOffset)
+	assert.Error(t, err)
+
+	// RGBA, black with any alpha is water
+	img := image.NewNRGBA(image.Rect(0, 0, 80, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 80; x++ {
+			c := color.NRGBA{0, 0, 0, 255}
+			if x >= 40 {
+				c = color.NRGBA{0, 0, 1, 0}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	f, err := os.Create(filepath.Join(dir, FileName))
+	assert.NoError(t, err)
+	assert.NoError(t, png.Encode(f, img))
+	f.Close()
+
+	cm, err := LoadDir(dir, 0)
+	assert.NoError(t, err)
+	assert.True(t, cm.IsLand(0, 20))
+	yes, _, _, _ := cm.IsCoast(79, 20)
+	assert.True(t, yes)
+
+	os.WriteFile(filepath.Join(dir, FileName), []byte("no png"), 0644)
+	_, err = LoadDir(dir, 0)
+	assert.Error(t, err)
+}
+
+func TestFrom(t *testing.T) {
+	cm, err := New(mask(80, 40, func(x, y int) bool { return (x-20)*(x-20)+(y-20)*(y-20) < 50 }), 2)
+	assert.NoError(t, err)
+	assert.Equal(t, cm, From(cm, 80, 40, 2))
+}
+
+func TestWithLand(t *testing.T) {
+	const n, m = 80, 40
+	const offset = 2
+	shore := func(x, y int) bool { return x >= 40 || (x-20)*(x-20)+(y-20)*(y-20) < 20 }
+	cm, err := New(mask(n, m, shore), offset)
+	assert.NoError(t, err)
+	assert.Same(t, cm, cm.WithLand(func(i, j int) bool { return false }))
+
+	// a frozen bay in grid coordinates, x = i + offset + n/2, y = m - j - offset
+	ice := func(i, j int) bool { return i >= 65 && i < 75 && j >= 12 && j < 25 }
+	frozen := cm.WithLand(ice)
+
+	// the same as land in the mask
+	ref, err := New(mask(n, m, func(x, y int) bool {
+		i := (x - offset - n/2 + n) % n
+		return shore(x, y) || ice(i, m-y-offset)
+	}), offset)
+	assert.NoError(t, err)
+	assert.Equal(t, ref, frozen)
+
+	assert.True(t, frozen.IsLand(70, 20))
+	assert.True(t, cm.IsWater(70, 20))
+	yes, dx, _, _ := frozen.IsCoast(64, 20)
+	assert.True(t, yes)
+	assert.Equal(t, 1, dx)
+}
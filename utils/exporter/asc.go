@@ -0,0 +1,48 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const ascNoData = -9999
+
+// ESRI ASCII grid, cell centers are the grid points
+func WriteAsciiGrid(wr io.Writer, r Raster, opt *Options) error {
+	w, err := newWindow(r, opt.BBox)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(wr)
+	fmt.Fprintf(bw, "ncols %d\nnrows %d\n", w.nCol, w.nRow)
+	fmt.Fprintf(bw, "xllcenter %g\nyllcenter %g\n", w.west(), w.north()-float32(w.nRow-1)*w.geo.DLat)
+	if w.geo.DLon == w.geo.DLat {
+		fmt.Fprintf(bw, "cellsize %g\n", w.geo.DLon)
+	} else {
+		// GDAL extension
+		fmt.Fprintf(bw, "dx %g\ndy %g\n", w.geo.DLon, w.geo.DLat)
+	}
+	fmt.Fprintf(bw, "NODATA_value %d\n", ascNoData)
+
+	buf := make([]byte, 0, 16)
+	for row := 0; row < w.nRow; row++ {
+		for col := 0; col < w.nCol; col++ {
+			if col > 0 {
+				bw.WriteByte(' ')
+			}
+			v := w.at(col, row)
+			if (v == w.geo.NoData && v != 0) || v != v {
+				buf = strconv.AppendInt(buf[:0], ascNoData, 10)
+			} else {
+				buf = strconv.AppendFloat(buf[:0], float64(v), 'g', 5, 32)
+			}
+			bw.Write(buf)
+		}
+		bw.WriteByte('\n')
+	}
+
+	return bw.Flush()
+}
@@ -0,0 +1,355 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// snow line, 5 cm, 20 cm
+var DefaultContourThresholds = []float32{0.01, 0.05, 0.20}
+
+// coordinates are rounded to ~10 m
+const contourDigits = 1e4
+
+// a ring is closed: the last point repeats the first
+type Ring [][2]float64
+
+// outer ring counterclockwise, then the holes clockwise (RFC 7946)
+type Polygon []Ring
+
+// "0.01,0.05,0.2"
+func ParseThresholds(s string) ([]float32, error) {
+	var t []float32
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 32)
+		if err != nil || v <= 0 {
+			return nil, fmt.Errorf("invalid contour threshold '%s'", f)
+		}
+		t = append(t, float32(v))
+	}
+	sort.Slice(t, func(i, j int) bool { return t[i] < t[j] })
+	return t, nil
+}
+
+// Areas with values >= threshold as polygons, traced with marching squares on the grid points.
+// Undefined points count as below the threshold, areas touching the border of the window
+// are closed along it. tolerance > 0 simplifies the rings with Douglas-Peucker (in °).
+func Contours(r Raster, bb *BBox, threshold float32, tolerance float64) ([]Polygon, error) {
+	w, err := newWindow(r, bb)
+	if err != nil {
+		return nil, err
+	}
+
+	// y up, padded by one point of "below" on each side
+	nx, ny := w.nCol, w.nRow
+	inside := make([]bool, (nx+2)*(ny+2))
+	val := make([]float32, nx*ny)
+	for row := 0; row < ny; row++ {
+		y := ny - 1 - row
+		for x := 0; x < nx; x++ {
+			v := w.at(x, row)
+			val[x*ny+y] = v
+			inside[(x+1)*(ny+2)+y+1] = v >= threshold && !(v == w.geo.NoData && v != 0)
+		}
+	}
+	in := func(x, y int) bool {
+		return inside[(x+1)*(ny+2)+y+1]
+	}
+
+	// Points are on the edges between grid points and named by them: horizontal edge (x,y)-(x+1,y)
+	// or vertical edge (x,y)-(x,y+1). Segments keep the inside on their left, so every point
+	// has one successor.
+	key := func(x, y int, horiz bool) int64 {
+		k := (int64(x+1)*int64(ny+2) + int64(y+1)) << 1
+		if horiz {
+			k |= 1
+		}
+		return k
+	}
+	next := make(map[int64]int64)
+
+	type crossing struct {
+		k     int64
+		inOut bool // from inside to outside going counterclockwise around the cell
+	}
+	var c [4]crossing
+	for x := -1; x < nx; x++ {
+		for y := -1; y < ny; y++ {
+			// counterclockwise: bottom left, bottom right, top right, top left
+			corner := [4]bool{in(x, y), in(x+1, y), in(x+1, y+1), in(x, y+1)}
+			if corner[0] == corner[1] && corner[1] == corner[2] && corner[2] == corner[3] {
+				continue
+			}
+			edges := [4]int64{key(x, y, true), key(x+1, y, false), key(x, y+1, true), key(x, y, false)}
+			n := 0
+			for e := 0; e < 4; e++ {
+				if corner[e] != corner[(e+1)%4] {
+					c[n] = crossing{edges[e], corner[e]}
+					n++
+				}
+			}
+
+			// saddle: the inside corners are connected if the center is inside
+			connected := true
+			if n == 4 {
+				sum := float32(0)
+				for _, p := range [4][2]int{{x, y}, {x + 1, y}, {x + 1, y + 1}, {x, y + 1}} {
+					sum += val[p[0]*ny+p[1]]
+				}
+				connected = sum/4 >= threshold
+			}
+			for k := 0; k < n; k++ {
+				if !c[k].inOut {
+					continue
+				}
+				to := c[(k+n-1)%n]
+				if connected {
+					to = c[(k+1)%n]
+				}
+				next[c[k].k] = to.k
+			}
+		}
+	}
+
+	// position of a point by linear interpolation, on the grid point next to the padding
+	geo := w.geo
+	west, south := float64(w.west()), float64(w.north())-float64(ny-1)*float64(geo.DLat)
+	point := func(k int64) [2]float64 {
+		horiz := k&1 == 1
+		k >>= 1
+		x, y := int(k/int64(ny+2))-1, int(k%int64(ny+2))-1
+		x1, y1 := x, y+1
+		if horiz {
+			x1, y1 = x+1, y
+		}
+
+		var fx, fy float64
+		switch {
+		case x < 0 || y < 0:
+			fx, fy = float64(x1), float64(y1)
+		case x1 >= nx || y1 >= ny:
+			fx, fy = float64(x), float64(y)
+		default:
+			v0, v1 := val[x*ny+y], val[x1*ny+y1]
+			t := float64(0.5)
+			if v1 != v0 {
+				t = float64((threshold - v0) / (v1 - v0))
+				t = min(1, max(0, t))
+			}
+			fx = float64(x) + t*float64(x1-x)
+			fy = float64(y) + t*float64(y1-y)
+		}
+		return [2]float64{
+			math.Round((west+fx*float64(geo.DLon))*contourDigits) / contourDigits,
+			math.Round((south+fy*float64(geo.DLat))*contourDigits) / contourDigits,
+		}
+	}
+
+	// follow the segments into rings, each starts at its smallest key so simplification and the
+	// order of the polygons don't depend on the map's iteration order
+	keys := make([]int64, 0, len(next))
+	for k := range next {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	var outer, holes []Ring
+	for _, start := range keys {
+		if _, ok := next[start]; !ok {
+			continue // part of an earlier ring
+		}
+
+		var ring Ring
+		for k := start; ; {
+			p := point(k)
+			if len(ring) == 0 || ring[len(ring)-1] != p {
+				ring = append(ring, p)
+			}
+			n, ok := next[k]
+			if !ok {
+				return nil, fmt.Errorf("contour %g: open ring", threshold)
+			}
+			delete(next, k)
+			if k = n; k == start {
+				break
+			}
+		}
+		if len(ring) > 1 && ring[0] == ring[len(ring)-1] {
+			ring = ring[:len(ring)-1]
+		}
+		if len(ring) < 3 {
+			continue
+		}
+		ring = append(ring, ring[0])
+
+		if tolerance > 0 {
+			ring = simplifyRing(ring, tolerance)
+			if ring == nil {
+				continue
+			}
+		}
+
+		if a := ringArea(ring); a > 0 {
+			outer = append(outer, ring)
+		} else if a < 0 {
+			holes = append(holes, ring)
+		}
+	}
+
+	// each hole goes to the smallest outer ring around it
+	polys := make([]Polygon, len(outer))
+	area := make([]float64, len(outer))
+	for i, o := range outer {
+		polys[i] = Polygon{o}
+		area[i] = ringArea(o)
+	}
+	for _, h := range holes {
+		best := -1
+		for i, o := range outer {
+			if (best < 0 || area[i] < area[best]) && area[i] > -ringArea(h) && inRing(o, h[0]) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			polys[best] = append(polys[best], h)
+		}
+	}
+
+	// stable output
+	sort.Slice(polys, func(i, j int) bool {
+		a, b := polys[i][0][0], polys[j][0][0]
+		if a[1] != b[1] {
+			return a[1] > b[1]
+		}
+		return a[0] < b[0]
+	})
+	return polys, nil
+}
+
+// signed area, > 0 for counterclockwise
+func ringArea(r Ring) float64 {
+	a := float64(0)
+	for i := 0; i+1 < len(r); i++ {
+		a += r[i][0]*r[i+1][1] - r[i+1][0]*r[i][1]
+	}
+	return a / 2
+}
+
+// even-odd rule
+func inRing(r Ring, p [2]float64) bool {
+	in := false
+	for i := 0; i+1 < len(r); i++ {
+		a, b := r[i], r[i+1]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+	return in
+}
+
+// Douglas-Peucker on a closed ring, split at the point farthest from the start
+// nil if it collapses
+func simplifyRing(r Ring, tolerance float64) Ring {
+	n := len(r) - 1
+	far, dmax := 0, float64(-1)
+	for i := 1; i < n; i++ {
+		dx, dy := r[i][0]-r[0][0], r[i][1]-r[0][1]
+		if d := dx*dx + dy*dy; d > dmax {
+			far, dmax = i, d
+		}
+	}
+
+	keep := make([]bool, n+1)
+	keep[0], keep[far], keep[n] = true, true, true
+	douglasPeucker(r, 0, far, tolerance, keep)
+	douglasPeucker(r, far, n, tolerance, keep)
+
+	out := make(Ring, 0, n+1)
+	for i, p := range r {
+		if keep[i] {
+			out = append(out, p)
+		}
+	}
+	if len(out) < 4 {
+		return nil
+	}
+	return out
+}
+
+func douglasPeucker(r Ring, i0, i1 int, tolerance float64, keep []bool) {
+	if i1-i0 < 2 {
+		return
+	}
+	a, b := r[i0], r[i1]
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	l := math.Hypot(dx, dy)
+
+	far, dmax := -1, tolerance
+	for i := i0 + 1; i < i1; i++ {
+		var d float64
+		if l == 0 {
+			d = math.Hypot(r[i][0]-a[0], r[i][1]-a[1])
+		} else {
+			d = math.Abs(dy*(r[i][0]-a[0])-dx*(r[i][1]-a[1])) / l
+		}
+		if d > dmax {
+			far, dmax = i, d
+		}
+	}
+	if far < 0 {
+		return
+	}
+	keep[far] = true
+	douglasPeucker(r, i0, far, tolerance, keep)
+	douglasPeucker(r, far, i1, tolerance, keep)
+}
+
+type geoJSONFeature struct {
+	Type       string         `json:"type"`
+	Properties map[string]any `json:"properties"`
+	Geometry   struct {
+		Type        string    `json:"type"`
+		Coordinates []Polygon `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+// GeoJSON FeatureCollection with one MultiPolygon per threshold, property "threshold" in m
+func WriteGeoJSON(wr io.Writer, r Raster, opt *Options) error {
+	thresholds := opt.Thresholds
+	if len(thresholds) == 0 {
+		thresholds = DefaultContourThresholds
+	}
+
+	features := make([]geoJSONFeature, len(thresholds))
+	for i, t := range thresholds {
+		polys, err := Contours(r, opt.BBox, t, opt.Simplify)
+		if err != nil {
+			return err
+		}
+		f := &features[i]
+		f.Type = "Feature"
+		f.Properties = map[string]any{"threshold": t}
+		f.Geometry.Type = "MultiPolygon"
+		f.Geometry.Coordinates = polys
+		if polys == nil {
+			f.Geometry.Coordinates = []Polygon{}
+		}
+	}
+
+	bw := bufio.NewWriter(wr)
+	enc := json.NewEncoder(bw)
+	err := enc.Encode(struct {
+		Type     string           `json:"type"`
+		Features []geoJSONFeature `json:"features"`
+	}{"FeatureCollection", features})
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,167 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"path/filepath"
+	"testing"
+)
+
+// 20x20 1° grid at 0/0
+type funcRaster func(i, j int) float32
+
+func (r funcRaster) Geometry() Geometry {
+	return Geometry{DLon: 1, DLat: 1, NLon: 20, NLat: 20}
+}
+
+func (r funcRaster) GetIdx(iLon, iLat int) float32 {
+	return r(iLon, iLat)
+}
+
+func block(i0, j0, n int) funcRaster {
+	return func(i, j int) float32 {
+		if i >= i0 && i < i0+n && j >= j0 && j < j0+n {
+			return 1
+		}
+		return 0
+	}
+}
+
+func TestContourBlock(t *testing.T) {
+	polys, err := Contours(block(2, 3, 3), nil, 0.5, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(polys))
+	assert.Equal(t, 1, len(polys[0]))
+
+	// 3x3 points reach half way to the neighbours, the corners are cut
+	r := polys[0][0]
+	assert.Equal(t, r[0], r[len(r)-1])
+	assert.InDelta(t, 9-4*0.125, ringArea(r), 1e-9)
+	assert.True(t, inRing(r, [2]float64{3, 4}))
+	assert.False(t, inRing(r, [2]float64{5.6, 4}))
+
+	_, err = Contours(block(2, 3, 3), &BBox{West: 50, South: 0, East: 60, North: 10}, 0.5, 0)
+	assert.Error(t, err)
+}
+
+func TestContourHole(t *testing.T) {
+	r := funcRaster(func(i, j int) float32 {
+		if block(5, 5, 1)(i, j) > 0 {
+			return 0
+		}
+		return block(3, 3, 5)(i, j)
+	})
+	polys, err := Contours(r, nil, 0.5, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(polys))
+	assert.Equal(t, 2, len(polys[0]))
+	assert.Greater(t, ringArea(polys[0][0]), 0.0)
+	assert.InDelta(t, -0.5, ringArea(polys[0][1]), 1e-9)
+	assert.True(t, inRing(polys[0][1], [2]float64{5, 5}))
+}
+
+func TestContourBorder(t *testing.T) {
+	// everything is inside: closed along the border of the map
+	all := funcRaster(func(i, j int) float32 { return 1 })
+	polys, err := Contours(all, nil, 0.5, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(polys))
+	assert.InDelta(t, 19*19, ringArea(polys[0][0]), 1e-9)
+
+	// clipped by the bounding box
+	polys, err = Contours(all, &BBox{West: 2, South: 2, East: 5, North: 4}, 0.5, 0)
+	assert.NoError(t, err)
+	assert.InDelta(t, 3*2, ringArea(polys[0][0]), 1e-9)
+
+	polys, err = Contours(all, nil, 2, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(polys))
+}
+
+func TestContourSaddle(t *testing.T) {
+	r := funcRaster(func(i, j int) float32 {
+		if (i == 3 && j == 3) || (i == 4 && j == 4) {
+			return 1
+		}
+		return 0
+	})
+
+	// center 0.5: connected
+	polys, err := Contours(r, nil, 0.5, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(polys))
+
+	polys, err = Contours(r, nil, 0.6, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(polys))
+}
+
+func TestContourSimplify(t *testing.T) {
+	disc := funcRaster(func(i, j int) float32 {
+		return float32(8 - math.Hypot(float64(i)-10, float64(j)-10))
+	})
+	full, err := Contours(disc, nil, 0, 0)
+	assert.NoError(t, err)
+	simple, err := Contours(disc, nil, 0, 0.2)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, len(simple))
+	assert.Less(t, len(simple[0][0]), len(full[0][0])/2)
+	assert.InDelta(t, math.Pi*64, ringArea(full[0][0]), 2)
+	assert.InEpsilon(t, ringArea(full[0][0]), ringArea(simple[0][0]), 0.05)
+
+	// collapses
+	tiny, err := Contours(block(2, 3, 1), nil, 0.5, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(tiny))
+}
+
+// the same map gives the same file, whatever the map iteration order
+func TestGeoJSONStable(t *testing.T) {
+	blobs := funcRaster(func(i, j int) float32 {
+		return float32(math.Sin(float64(i)*0.9)*math.Cos(float64(j)*0.7)) * 0.3
+	})
+	opt := &Options{Simplify: 0.2}
+	var first bytes.Buffer
+	assert.NoError(t, WriteGeoJSON(&first, blobs, opt))
+	for k := 0; k < 10; k++ {
+		var b bytes.Buffer
+		assert.NoError(t, WriteGeoJSON(&b, blobs, opt))
+		assert.Equal(t, first.String(), b.String(), "run %d", k)
+	}
+}
+
+func TestGeoJSON(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteGeoJSON(&b, block(2, 3, 3), &Options{})
+	assert.NoError(t, err)
+
+	var fc struct {
+		Type     string
+		Features []struct {
+			Properties map[string]float64
+			Geometry   struct {
+				Type        string
+				Coordinates [][][][2]float64
+			}
+		}
+	}
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &fc))
+	assert.Equal(t, "FeatureCollection", fc.Type)
+	assert.Equal(t, 3, len(fc.Features))
+	assert.InDelta(t, 0.05, fc.Features[1].Properties["threshold"], 1e-6)
+	assert.Equal(t, "MultiPolygon", fc.Features[0].Geometry.Type)
+	assert.Equal(t, 1, len(fc.Features[0].Geometry.Coordinates))
+
+	th, err := ParseThresholds("0.2, 0.01")
+	assert.NoError(t, err)
+	assert.Equal(t, []float32{0.01, 0.2}, th)
+	_, err = ParseThresholds("0.1,x")
+	assert.Error(t, err)
+
+	// wrapping world, across the antimeridian
+	fn := filepath.Join(t.TempDir(), "x.geojson")
+	assert.NoError(t, ExportFile(fn, world, &Options{BBox: &BBox{West: 170, South: -10, East: -170, North: 10}, Thresholds: []float32{9000}}))
+}
@@ -0,0 +1,187 @@
+// Package exporter writes depth maps in formats that GIS tools and image viewers understand.
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// geometry of a regular lon/lat grid, values are on the grid points
+type Geometry struct {
+	Lon0, Lat0 float32
+	DLon, DLat float32
+	NLon, NLat int
+	WrapLon    bool
+	NoData     float32
+}
+
+// what the exporters need from a map
+type Raster interface {
+	GetIdx(iLon, iLat int) float32
+	Geometry() Geometry
+}
+
+// area to export, East < West crosses the antimeridian
+type BBox struct {
+	West, South, East, North float32
+}
+
+func ParseBBox(s string) (*BBox, error) {
+	f := strings.Split(s, ",")
+	if len(f) != 4 {
+		return nil, fmt.Errorf("bounding box must be 'west,south,east,north': '%s'", s)
+	}
+	var v [4]float32
+	for i := range f {
+		x, err := strconv.ParseFloat(strings.TrimSpace(f[i]), 32)
+		if err != nil {
+			return nil, fmt.Errorf("bounding box '%s': %w", s, err)
+		}
+		v[i] = float32(x)
+	}
+	b := &BBox{v[0], v[1], v[2], v[3]}
+	if b.South >= b.North {
+		return nil, fmt.Errorf("bounding box '%s': south >= north", s)
+	}
+	return b, nil
+}
+
+type Options struct {
+	BBox *BBox   // nil = everything
+	Ramp *Ramp   // for PNG, nil = SnowRamp
+	Max  float32 // PNG: values >= Max get the last colour of the ramp, 0 = ramp's default
+
+	Thresholds []float32 // GeoJSON contours in m, nil = DefaultContourThresholds
+	Simplify   float64   // GeoJSON: tolerance of the simplification in °, 0 = none
+}
+
+// a rectangular part of a raster in image order: row 0 is north, column 0 is west
+type window struct {
+	r          Raster
+	geo        Geometry
+	iLon0      int // first column, may be negative or beyond NLon for wrapping grids
+	jNorth     int // lat index of row 0
+	nCol, nRow int
+}
+
+// lon/lat of the first column/row
+func (w *window) west() float32 {
+	return w.geo.Lon0 + float32(w.iLon0)*w.geo.DLon
+}
+
+func (w *window) north() float32 {
+	return w.geo.Lat0 + float32(w.jNorth)*w.geo.DLat
+}
+
+func (w *window) at(col, row int) float32 {
+	i := w.iLon0 + col
+	if w.geo.WrapLon {
+		i %= w.geo.NLon
+		if i < 0 {
+			i += w.geo.NLon
+		}
+	}
+	return w.r.GetIdx(i, w.jNorth-row)
+}
+
+func newWindow(r Raster, bb *BBox) (*window, error) {
+	g := r.Geometry()
+	if g.DLon <= 0 || g.DLat <= 0 || g.NLon <= 0 || g.NLat <= 0 {
+		return nil, fmt.Errorf("invalid geometry")
+	}
+
+	w := &window{r: r, geo: g}
+
+	// longitude relative to Lon0 in [0, 360)
+	rel := func(lon float32) float64 {
+		d := math.Mod(float64(lon-g.Lon0), 360)
+		if d < 0 {
+			d += 360
+		}
+		return d
+	}
+
+	if bb == nil {
+		if g.WrapLon {
+			bb = &BBox{West: -180, South: -90, East: 180, North: 90}
+		} else {
+			w.iLon0, w.nCol = 0, g.NLon
+			bb = &BBox{South: -90, North: 90}
+		}
+	}
+
+	if w.nCol == 0 {
+		d := float64(g.DLon)
+		if g.WrapLon {
+			span := math.Mod(float64(bb.East-bb.West), 360)
+			if span <= 0 {
+				span += 360
+			}
+			i0 := int(math.Ceil(rel(bb.West)/d - 1e-4))
+			n := min(g.NLon, int(math.Floor(span/d+1e-4))+1)
+			// grids starting at 0 are shown from -180 on
+			if i0 >= g.NLon/2 && g.Lon0 >= 0 {
+				i0 -= g.NLon
+			}
+			w.iLon0, w.nCol = i0, n
+		} else {
+			// relative to Lon0 in [-180, 180)
+			rel_s := func(lon float32) float64 {
+				r := rel(lon)
+				if r >= 180 {
+					r -= 360
+				}
+				return r
+			}
+			i0 := max(0, int(math.Ceil(rel_s(bb.West)/d-1e-4)))
+			i1 := min(g.NLon-1, int(math.Floor(rel_s(bb.East)/d+1e-4)))
+			w.iLon0, w.nCol = i0, i1-i0+1
+		}
+	}
+
+	d := float64(g.DLat)
+	js := max(0, int(math.Ceil(float64(bb.South-g.Lat0)/d-1e-4)))
+	jn := min(g.NLat-1, int(math.Floor(float64(bb.North-g.Lat0)/d+1e-4)))
+	if jn < js || w.nCol <= 0 {
+		return nil, fmt.Errorf("bounding box does not intersect the map")
+	}
+	w.jNorth, w.nRow = jn, jn-js+1
+	return w, nil
+}
+
+// the format is taken from the extension: .png, .tif/.tiff, .asc or .geojson
+func ExportFile(path string, r Raster, opt *Options) error {
+	if opt == nil {
+		opt = &Options{}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		err = WritePng(f, r, opt)
+	case ".tif", ".tiff":
+		err = WriteGeoTiff(f, r, opt)
+	case ".asc":
+		err = WriteAsciiGrid(f, r, opt)
+	case ".geojson", ".json":
+		err = WriteGeoJSON(f, r, opt)
+	default:
+		err = fmt.Errorf("unknown export format '%s'", filepath.Ext(path))
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
@@ -0,0 +1,131 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"image/png"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// value = iLon + 1000 * iLat
+type testRaster struct {
+	geo Geometry
+}
+
+func (r *testRaster) Geometry() Geometry {
+	return r.geo
+}
+
+func (r *testRaster) GetIdx(iLon, iLat int) float32 {
+	return float32(iLon + 1000*iLat)
+}
+
+// 10° world starting at 0
+var world = &testRaster{Geometry{Lon0: 0, Lat0: -90, DLon: 10, DLat: 10, NLon: 36, NLat: 19, WrapLon: true}}
+
+func TestWindow(t *testing.T) {
+	w, err := newWindow(world, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 36, w.nCol)
+	assert.Equal(t, 19, w.nRow)
+	assert.Equal(t, float32(-180), w.west())
+	assert.Equal(t, float32(90), w.north())
+	assert.Equal(t, float32(18+18*1000), w.at(0, 0)) // -180, 90
+
+	// across the antimeridian
+	w, err = newWindow(world, &BBox{West: 170, South: -10, East: -170, North: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, w.nCol)
+	assert.Equal(t, 3, w.nRow)
+	assert.Equal(t, float32(170), w.west())
+	assert.Equal(t, float32(17+10*1000), w.at(0, 0))
+	assert.Equal(t, float32(19+8*1000), w.at(2, 2))
+
+	regional := &testRaster{Geometry{Lon0: 5, Lat0: 40, DLon: 1, DLat: 1, NLon: 10, NLat: 10}}
+	w, err = newWindow(regional, &BBox{West: 0, South: 45, East: 7, North: 60})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, w.nCol)
+	assert.Equal(t, 5, w.nRow)
+	assert.Equal(t, float32(5), w.west())
+	assert.Equal(t, float32(49), w.north())
+
+	_, err = newWindow(regional, &BBox{West: 20, South: 45, East: 30, North: 60})
+	assert.Error(t, err)
+
+	_, err = ParseBBox("1,2,3")
+	assert.Error(t, err)
+	bb, err := ParseBBox("-10, 40, 20, 60")
+	assert.NoError(t, err)
+	assert.Equal(t, BBox{-10, 40, 20, 60}, *bb)
+}
+
+func TestAsciiGrid(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteAsciiGrid(&b, world, &Options{BBox: &BBox{West: 0, South: 0, East: 10, North: 10}})
+	assert.NoError(t, err)
+	assert.Equal(t, "ncols 2\nnrows 2\nxllcenter 0\nyllcenter 0\ncellsize 10\nNODATA_value -9999\n"+
+		"10000 10001\n9000 9001\n", b.String())
+}
+
+func TestGeoTiff(t *testing.T) {
+	var b bytes.Buffer
+	err := WriteGeoTiff(&b, world, &Options{BBox: &BBox{West: 0, South: 0, East: 20, North: 10}})
+	assert.NoError(t, err)
+
+	data := b.Bytes()
+	assert.Equal(t, "II", string(data[:2]))
+	assert.Equal(t, uint16(42), binary.LittleEndian.Uint16(data[2:]))
+
+	// image is at the end: 3 x 2 float32
+	img := data[len(data)-24:]
+	assert.Equal(t, float32(10000), math.Float32frombits(binary.LittleEndian.Uint32(img)))
+	assert.Equal(t, float32(9002), math.Float32frombits(binary.LittleEndian.Uint32(img[20:])))
+
+	// tie point: upper left corner of the first pixel
+	tp := bytes.Index(data, binary.LittleEndian.AppendUint64(nil, math.Float64bits(-5)))
+	assert.Greater(t, tp, 0)
+	assert.Equal(t, 15.0, math.Float64frombits(binary.LittleEndian.Uint64(data[tp+8:])))
+
+	// 0 is no snow, so no nodata tag
+	assert.False(t, hasTiffTag(data, tagGdalNoData))
+	b.Reset()
+	missing := &testRaster{world.geo}
+	missing.geo.NoData = -1
+	assert.NoError(t, WriteGeoTiff(&b, missing, &Options{}))
+	assert.True(t, hasTiffTag(b.Bytes(), tagGdalNoData))
+}
+
+func hasTiffTag(data []byte, tag uint16) bool {
+	ifd := binary.LittleEndian.Uint32(data[4:])
+	n := int(binary.LittleEndian.Uint16(data[ifd:]))
+	for i := 0; i < n; i++ {
+		if binary.LittleEndian.Uint16(data[int(ifd)+2+12*i:]) == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPng(t *testing.T) {
+	var b bytes.Buffer
+	err := WritePng(&b, world, &Options{Max: 20000})
+	assert.NoError(t, err)
+
+	img, err := png.Decode(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, 36, img.Bounds().Dx())
+	assert.Equal(t, 19, img.Bounds().Dy())
+
+	// south pole is below the first ramp stop
+	_, _, _, a := img.At(0, 18).RGBA()
+	assert.Equal(t, uint32(0), a)
+	_, _, _, a = img.At(0, 0).RGBA()
+	assert.NotEqual(t, uint32(0), a)
+
+	err = ExportFile(filepath.Join(t.TempDir(), "x.jpg"), world, nil)
+	assert.True(t, strings.Contains(err.Error(), "unknown"))
+}
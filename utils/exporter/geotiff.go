@@ -0,0 +1,163 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// TIFF tags
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagPlanarConfig    = 284
+	tagSampleFormat    = 339
+	tagModelPixelScale = 33550
+	tagModelTiepoint   = 33922
+	tagGeoKeyDirectory = 34735
+	tagGdalNoData      = 42113
+)
+
+// TIFF field types
+const (
+	tiffAscii  = 2
+	tiffShort  = 3
+	tiffLong   = 4
+	tiffDouble = 12
+)
+
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	data     []byte // little endian
+}
+
+func shortsEntry(tag uint16, v ...uint16) tiffEntry {
+	b := make([]byte, 0, 2*len(v))
+	for _, x := range v {
+		b = binary.LittleEndian.AppendUint16(b, x)
+	}
+	return tiffEntry{tag, tiffShort, uint32(len(v)), b}
+}
+
+func longEntry(tag uint16, v uint32) tiffEntry {
+	return tiffEntry{tag, tiffLong, 1, binary.LittleEndian.AppendUint32(nil, v)}
+}
+
+func doublesEntry(tag uint16, v ...float64) tiffEntry {
+	b := make([]byte, 0, 8*len(v))
+	for _, x := range v {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(x))
+	}
+	return tiffEntry{tag, tiffDouble, uint32(len(v)), b}
+}
+
+func asciiEntry(tag uint16, s string) tiffEntry {
+	b := append([]byte(s), 0)
+	return tiffEntry{tag, tiffAscii, uint32(len(b)), b}
+}
+
+// single band float32 GeoTIFF in WGS84, one strip, uncompressed
+func WriteGeoTiff(wr io.Writer, r Raster, opt *Options) error {
+	w, err := newWindow(r, opt.BBox)
+	if err != nil {
+		return err
+	}
+
+	img := make([]byte, 0, 4*w.nCol*w.nRow)
+	for row := 0; row < w.nRow; row++ {
+		for col := 0; col < w.nCol; col++ {
+			img = binary.LittleEndian.AppendUint32(img, math.Float32bits(w.at(col, row)))
+		}
+	}
+
+	// grid points are pixel centers, the tie point is the upper left corner of the first pixel
+	dlon, dlat := float64(w.geo.DLon), float64(w.geo.DLat)
+	west := float64(w.west()) - dlon/2
+	north := float64(w.north()) + dlat/2
+
+	entries := []tiffEntry{
+		longEntry(tagImageWidth, uint32(w.nCol)),
+		longEntry(tagImageLength, uint32(w.nRow)),
+		shortsEntry(tagBitsPerSample, 32),
+		shortsEntry(tagCompression, 1),
+		shortsEntry(tagPhotometric, 1), // black is zero
+		longEntry(tagStripOffsets, 0),  // patched below
+		shortsEntry(tagSamplesPerPixel, 1),
+		longEntry(tagRowsPerStrip, uint32(w.nRow)),
+		longEntry(tagStripByteCounts, uint32(len(img))),
+		shortsEntry(tagPlanarConfig, 1),
+		shortsEntry(tagSampleFormat, 3), // IEEE float
+		doublesEntry(tagModelPixelScale, dlon, dlat, 0),
+		doublesEntry(tagModelTiepoint, 0, 0, 0, west, north, 0),
+		// version 1.1.0, 3 keys: geographic model, pixel is area, WGS84
+		shortsEntry(tagGeoKeyDirectory, 1, 1, 0, 3, 1024, 0, 1, 2, 1025, 0, 1, 1, 2048, 0, 1, 4326),
+	}
+	if w.geo.NoData != 0 {
+		// 0 is no snow, not missing data
+		entries = append(entries, asciiEntry(tagGdalNoData, strconv.FormatFloat(float64(w.geo.NoData), 'g', -1, 32)))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+	// layout: header, IFD, out of line values, image
+	const hdrSize = 8
+	ifdSize := 2 + 12*len(entries) + 4
+	extra := uint32(hdrSize + ifdSize)
+	offsets := make([]uint32, len(entries))
+	for i, e := range entries {
+		if len(e.data) > 4 {
+			offsets[i] = extra
+			extra += uint32(len(e.data) + len(e.data)&1) // word alignment
+		}
+	}
+	for i := range entries {
+		if entries[i].tag == tagStripOffsets {
+			entries[i].data = binary.LittleEndian.AppendUint32(nil, extra)
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString("II")
+	binary.Write(&b, binary.LittleEndian, uint16(42))
+	binary.Write(&b, binary.LittleEndian, uint32(hdrSize))
+
+	binary.Write(&b, binary.LittleEndian, uint16(len(entries)))
+	for i, e := range entries {
+		binary.Write(&b, binary.LittleEndian, e.tag)
+		binary.Write(&b, binary.LittleEndian, e.typ)
+		binary.Write(&b, binary.LittleEndian, e.count)
+		if len(e.data) > 4 {
+			binary.Write(&b, binary.LittleEndian, offsets[i])
+		} else {
+			var v [4]byte
+			copy(v[:], e.data)
+			b.Write(v[:])
+		}
+	}
+	binary.Write(&b, binary.LittleEndian, uint32(0)) // no next IFD
+
+	for _, e := range entries {
+		if len(e.data) > 4 {
+			b.Write(e.data)
+			if len(e.data)&1 != 0 {
+				b.WriteByte(0)
+			}
+		}
+	}
+
+	if _, err := wr.Write(b.Bytes()); err != nil {
+		return err
+	}
+	_, err = wr.Write(img)
+	return err
+}
@@ -0,0 +1,91 @@
+package exporter
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+type RampStop struct {
+	Value float32 // fraction of Options.Max
+	Color color.NRGBA
+}
+
+// piecewise linear colour ramp, values below the first stop are transparent
+type Ramp struct {
+	Stops []RampStop
+	Max   float32 // default for Options.Max
+}
+
+// dark teal for a dusting to white for deep snow, max 0.5 m
+var SnowRamp = &Ramp{
+	Stops: []RampStop{
+		{0.02, color.NRGBA{0, 70, 70, 255}},
+		{0.2, color.NRGBA{0, 160, 200, 255}},
+		{0.5, color.NRGBA{120, 200, 255, 255}},
+		{1.0, color.NRGBA{255, 255, 255, 255}},
+	},
+	Max: 0.5,
+}
+
+// difference maps: blue for less snow, red for more, fading out around 0, max ±0.2 m
+var DiffRamp = &Ramp{
+	Stops: []RampStop{
+		{-1e9, color.NRGBA{0, 60, 200, 255}},
+		{-1, color.NRGBA{0, 60, 200, 255}},
+		{0, color.NRGBA{255, 255, 255, 0}},
+		{1, color.NRGBA{200, 30, 0, 255}},
+	},
+	Max: 0.2,
+}
+
+func lerp8(a, b uint8, x float32) uint8 {
+	return uint8(float32(a) + x*(float32(b)-float32(a)) + 0.5)
+}
+
+// v is relative to max
+func (rp *Ramp) color(v float32) color.NRGBA {
+	s := rp.Stops
+	if len(s) == 0 || v < s[0].Value || v != v {
+		return color.NRGBA{}
+	}
+	for i := 1; i < len(s); i++ {
+		if v < s[i].Value {
+			x := (v - s[i-1].Value) / (s[i].Value - s[i-1].Value)
+			c0, c1 := s[i-1].Color, s[i].Color
+			return color.NRGBA{lerp8(c0.R, c1.R, x), lerp8(c0.G, c1.G, x), lerp8(c0.B, c1.B, x), lerp8(c0.A, c1.A, x)}
+		}
+	}
+	return s[len(s)-1].Color
+}
+
+// one pixel per grid point, north up
+func WritePng(wr io.Writer, r Raster, opt *Options) error {
+	w, err := newWindow(r, opt.BBox)
+	if err != nil {
+		return err
+	}
+
+	ramp := opt.Ramp
+	if ramp == nil {
+		ramp = SnowRamp
+	}
+	vmax := opt.Max
+	if vmax <= 0 {
+		vmax = ramp.Max
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, w.nCol, w.nRow))
+	for row := 0; row < w.nRow; row++ {
+		for col := 0; col < w.nCol; col++ {
+			v := w.at(col, row)
+			if v == w.geo.NoData && v != 0 {
+				continue
+			}
+			img.SetNRGBA(col, row, ramp.color(v/vmax))
+		}
+	}
+
+	return png.Encode(wr, img)
+}